@@ -0,0 +1,44 @@
+package history
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/history/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/history"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/kubeclient"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
+)
+
+var opts = options.NewHistoryOptions()
+
+var Cmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show the upgrades and rollbacks recorded for the gateways",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kubeClient, err := kubeclient.New(opts.KubeConfigPath)
+		if err != nil {
+			return fmt.Errorf("failed to init client, %v", err)
+		}
+		entries, err := history.New(kubeClient, upgrade.ExtensionNamespace).List(signals.SetupSignalHandler())
+		if err != nil {
+			return fmt.Errorf("failed to read history, %v", err)
+		}
+		var gateways []string
+		if opts.GatewayNames != "" && !upgrade.GetAll(opts.GatewayNames) {
+			for _, ref := range upgrade.NewGatewayReferences(opts.GatewayNames) {
+				gateways = append(gateways, ref.ToNamespacedName().String())
+			}
+		}
+		return history.Print(os.Stdout, history.Filter(entries, gateways), opts.Output)
+	},
+}
+
+func init() {
+	Cmd.Flags().StringVar(&opts.KubeConfigPath, "kubeconfig", "", "Path to the kubeconfig file ")
+	Cmd.Flags().StringVar(&opts.GatewayNames, "gateways", "", "Comma-separated list of gateway names to show the history of, defaults to every gateway")
+	Cmd.Flags().StringVarP(&opts.Output, "output", "o", "table", "Output format, table or json")
+}
@@ -0,0 +1,18 @@
+package options
+
+import (
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/options"
+)
+
+type HistoryOptions struct {
+	*options.Options
+	// Output is the format of the history, table or json.
+	Output string
+}
+
+func NewHistoryOptions() *HistoryOptions {
+	return &HistoryOptions{
+		Options: options.NewOptions(),
+		Output:  "table",
+	}
+}
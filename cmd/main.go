@@ -5,16 +5,33 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/history"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/manifest"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/render"
 	"github.com/zhou1203/GatewayUpgradeTool/cmd/rollback"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/status"
 	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/config"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/logging"
 )
 
 var rootCmd = &cobra.Command{
 	Use:   "gateway-upgrade-tool",
 	Short: "Gateway upgrade tool for managing gateway versions",
 	Long:  `A CLI tool to upgrade or rollback gateway components.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := config.Load(cmd, configFile); err != nil {
+			return err
+		}
+		return logging.Setup(logFormat)
+	},
 }
 
+var (
+	logFormat  string
+	configFile string
+)
+
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
@@ -23,9 +40,15 @@ func Execute() {
 }
 
 func init() {
+	rootCmd.PersistentFlags().StringVar(&configFile, config.FlagName, "", "Config file whose keys are flag names, flags set on the command line or as GATEWAY_UPGRADE_* environment variables take precedence")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatText, "Log format, text or json")
 	// 注册子命令
 	rootCmd.AddCommand(upgrade.Cmd)
 	rootCmd.AddCommand(rollback.Cmd)
+	rootCmd.AddCommand(render.Cmd)
+	rootCmd.AddCommand(history.Cmd)
+	rootCmd.AddCommand(status.Cmd)
+	rootCmd.AddCommand(manifest.Cmd)
 }
 
 func main() {
@@ -0,0 +1,58 @@
+package manifest
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/manifest/options"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/manifest"
+)
+
+var opts = options.NewManifestOptions()
+
+// upgradeFlags are the flags of the upgrade command, those set are passed on to the Job.
+var upgradeFlags = pflag.NewFlagSet("upgrade", pflag.ContinueOnError)
+
+var Cmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Print the PVC, Job, ServiceAccount and RBAC manifests running the upgrade in the cluster",
+	Long: `Print the manifests running the upgrade in the cluster. The upgrade flags given to this command,
+e.g. --gateways or --backup-enabled, are passed on to the upgrade Job.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts.Args = nil
+		upgradeFlags.VisitAll(func(f *pflag.Flag) {
+			// Values loaded from --config or the environment don't mark the flag changed.
+			if !f.Changed && f.Value.String() == f.DefValue {
+				return
+			}
+			if s, ok := f.Value.(pflag.SliceValue); ok {
+				for _, item := range s.GetSlice() {
+					opts.Args = append(opts.Args, fmt.Sprintf("--%s=%s", f.Name, item))
+				}
+				return
+			}
+			opts.Args = append(opts.Args, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+		})
+		objects, err := manifest.Objects(opts)
+		if err != nil {
+			return err
+		}
+		return manifest.Write(os.Stdout, objects)
+	},
+}
+
+func init() {
+	upgrade.AddFlags(upgradeFlags, opts.Upgrade)
+	Cmd.Flags().AddFlagSet(upgradeFlags)
+	Cmd.Flags().StringVar(&opts.Namespace, "namespace", opts.Namespace, "Namespace of the Job")
+	Cmd.Flags().StringVar(&opts.Image, "image", opts.Image, "Image of the gateway upgrade tool")
+	Cmd.Flags().StringVar(&opts.ServiceAccount, "service-account", opts.ServiceAccount, "ServiceAccount running the Job")
+	Cmd.Flags().BoolVar(&opts.CreateRBAC, "create-rbac", opts.CreateRBAC, "Create the ServiceAccount with a ClusterRole granting the permissions of the upgrade")
+	Cmd.Flags().StringVar(&opts.StorageSize, "storage-size", opts.StorageSize, "Size of the PVC holding the backups, created with --backup-enabled")
+	Cmd.Flags().StringVar(&opts.StorageClass, "storage-class", "", "Storage class of the PVC holding the backups")
+	Cmd.Flags().StringVar(&opts.ConfigMap, "config-map", "", "ConfigMap with an upgrade.yaml config file, mounted into the Job and passed with --config")
+}
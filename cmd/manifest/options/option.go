@@ -0,0 +1,34 @@
+package options
+
+import (
+	upgradeoptions "github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
+)
+
+type ManifestOptions struct {
+	// Upgrade holds the flags of the upgrade command, those set are passed to the Job.
+	Upgrade *upgradeoptions.RunOptions
+	// Args are the upgrade flags set on the command line, as passed to the Job.
+	Args      []string
+	Namespace string
+	Image     string
+	// ServiceAccount runs the Job. CreateRBAC creates it with a ClusterRole granting the permissions of the upgrade.
+	ServiceAccount string
+	CreateRBAC     bool
+	// StorageSize and StorageClass define the PVC holding the backups, created if backups are enabled.
+	StorageSize  string
+	StorageClass string
+	// ConfigMap holds an upgrade.yaml config file mounted into the Job and passed with --config.
+	ConfigMap string
+}
+
+func NewManifestOptions() *ManifestOptions {
+	return &ManifestOptions{
+		Upgrade:        upgradeoptions.NewRunOptions(),
+		Namespace:      upgrade.ExtensionNamespace,
+		Image:          "wenhaozhou/gateway-upgrade-tool:v0.0.1",
+		ServiceAccount: "gateway-upgrade-tool",
+		CreateRBAC:     true,
+		StorageSize:    "1Gi",
+	}
+}
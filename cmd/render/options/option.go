@@ -0,0 +1,24 @@
+package options
+
+import (
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/options"
+)
+
+type RenderOptions struct {
+	*options.Options
+	// FromBackup renders the gateways stored in a backup file instead of the live ones.
+	FromBackup string
+	// ChartPath is a local chart tgz of the target version, the embedded chart is used if it is empty.
+	ChartPath string
+	// GatewayConfigFile is a local copy of config.yaml from gateway-agent-backend-config.
+	GatewayConfigFile string
+	// Diff prints a per-object diff from the current release instead of the manifests.
+	Diff   bool
+	Output string
+}
+
+func NewRenderOptions() *RenderOptions {
+	return &RenderOptions{
+		Options: options.NewOptions(),
+	}
+}
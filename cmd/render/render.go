@@ -0,0 +1,51 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/render/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/render"
+)
+
+var opts = options.NewRenderOptions()
+
+var Cmd = &cobra.Command{
+	Use:   "render",
+	Short: "Render the manifests of the target gateway release without touching the cluster",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		renderer, err := render.NewRenderer(opts)
+		if err != nil {
+			return fmt.Errorf("failed to init renderer, %v", err)
+		}
+		var out io.Writer = os.Stdout
+		if opts.Output != "" {
+			file, err := os.Create(opts.Output)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			out = file
+		}
+		err = renderer.Run(signals.SetupSignalHandler(), out)
+		if err != nil {
+			return fmt.Errorf("failed to render, %v", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	Cmd.Flags().StringVar(&opts.KubeConfigPath, "kubeconfig", "", "Path to the kubeconfig file ")
+	Cmd.Flags().StringVar(&opts.GatewayNames, "gateways", "", "Comma-separated list of gateway names to render, '*' selects every gateway")
+	Cmd.Flags().StringVar(&opts.FromBackup, "from-backup", "", "Render the gateways stored in this backup file instead of the live ones")
+	Cmd.Flags().StringVar(&opts.Backup.EncryptionKeyFile, "backup-encryption-key-file", "", "File with the age identity or passphrase used to decrypt the backup")
+	Cmd.Flags().StringVar(&opts.ChartPath, "chart", "", "Path to the ingress-nginx chart tgz of the target version, defaults to the chart embedded in the binary")
+	Cmd.Flags().StringVar(&opts.GatewayConfigFile, "gateway-config", "", "Local copy of config.yaml from the gateway-agent-backend-config ConfigMap")
+	Cmd.Flags().BoolVar(&opts.Diff, "diff", false, "Print a per-object diff between the current release and the rendered one")
+	Cmd.Flags().StringVarP(&opts.Output, "output", "o", "", "File to write the manifests to, defaults to stdout")
+}
@@ -0,0 +1,22 @@
+package options
+
+import (
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/options"
+)
+
+type RollbackOptions struct {
+	*options.Options
+	// FromBackup is the backup file written by the upgrade command.
+	FromBackup string
+	// UseHelmHistory rolls back to the previous chart version found in the helm release history.
+	UseHelmHistory bool
+	DryRun         bool
+	// ForceUnlock takes over the run lock even if another run still holds it.
+	ForceUnlock bool
+}
+
+func NewRollbackOptions() *RollbackOptions {
+	return &RollbackOptions{
+		Options: options.NewOptions(),
+	}
+}
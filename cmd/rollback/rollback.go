@@ -2,14 +2,42 @@ package rollback
 
 import (
 	"fmt"
+	"time"
+
 	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/rollback/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/rollback"
 )
 
+var opts = options.NewRollbackOptions()
+
 var Cmd = &cobra.Command{
 	Use:   "rollback",
 	Short: "Rollback the gateway to the previous version",
-	Run: func(cmd *cobra.Command, args []string) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		fmt.Println("🔙 Starting to rollback...")
-		// 你可以在这里添加实际的回滚逻辑
+		newRunner, err := rollback.NewRunner(opts)
+		if err != nil {
+			return fmt.Errorf("failed to init runner, %v", err)
+		}
+		err = newRunner.Run(signals.SetupSignalHandler())
+		if err != nil {
+			return fmt.Errorf("failed to rollback, %v", err)
+		}
+		return nil
 	},
 }
+
+func init() {
+	Cmd.Flags().StringVar(&opts.KubeConfigPath, "kubeconfig", "", "Path to the kubeconfig file ")
+	Cmd.Flags().StringVar(&opts.GatewayNames, "gateways", "", "Comma-separated list of gateway names to rollback, '*' selects every gateway")
+	Cmd.Flags().StringVar(&opts.FromBackup, "from-backup", "", "Backup file to restore the gateways from")
+	Cmd.Flags().StringVar(&opts.Backup.EncryptionKeyFile, "backup-encryption-key-file", "", "File with the age identity or passphrase used to decrypt the backup")
+	Cmd.Flags().BoolVar(&opts.UseHelmHistory, "use-helm-history", false, "Rollback to the last release revision deployed with the previous chart version")
+	Cmd.Flags().DurationVar(&opts.Wait.ReadyTimeout, "ready-timeout", 5*time.Minute, "Time to wait for the resources of a gateway release to become ready")
+	Cmd.Flags().DurationVar(&opts.Wait.SettleDelay, "settle-delay", 5*time.Second, "Time to wait after updating a gateway before checking its release")
+	Cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Only print the difference between the live gateways and the rollback target")
+	Cmd.Flags().BoolVar(&opts.ForceUnlock, "force-unlock", false, "Take over the run lock held by another run, only use it if that run is gone")
+}
@@ -0,0 +1,22 @@
+package options
+
+import (
+	upgradeoptions "github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/options"
+)
+
+type StatusOptions struct {
+	*options.Options
+	// Output is the format of the inventory, table, json or yaml.
+	Output string
+	// TargetVersion is the app version a gateway has to run to be up to date.
+	TargetVersion string
+}
+
+func NewStatusOptions() *StatusOptions {
+	return &StatusOptions{
+		Options:       options.NewOptions(),
+		Output:        "table",
+		TargetVersion: upgradeoptions.DefaultTargetVersion,
+	}
+}
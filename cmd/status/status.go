@@ -0,0 +1,50 @@
+package status
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/status/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/kubeclient"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
+)
+
+var opts = options.NewStatusOptions()
+
+var Cmd = &cobra.Command{
+	Use:   "status",
+	Short: "List the gateways with their version, conditions, service and helm release",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kubeClient, err := kubeclient.New(opts.KubeConfigPath)
+		if err != nil {
+			return fmt.Errorf("failed to init client, %v", err)
+		}
+		var kubeconfig []byte
+		if opts.KubeConfigPath != "" {
+			kubeconfig, err = os.ReadFile(opts.KubeConfigPath)
+			if err != nil {
+				return err
+			}
+		}
+		var refs []*gatewayv2alpha2.GatewayReference
+		if opts.GatewayNames != "" && !upgrade.GetAll(opts.GatewayNames) {
+			refs = upgrade.NewGatewayReferences(opts.GatewayNames)
+		}
+		statuses, err := upgrade.GatewayStatuses(signals.SetupSignalHandler(), kubeClient, kubeconfig, refs, opts.TargetVersion)
+		if err != nil {
+			return fmt.Errorf("failed to list gateways, %v", err)
+		}
+		return upgrade.PrintStatuses(os.Stdout, statuses, opts.Output)
+	},
+}
+
+func init() {
+	Cmd.Flags().StringVar(&opts.KubeConfigPath, "kubeconfig", "", "Path to the kubeconfig file ")
+	Cmd.Flags().StringVar(&opts.GatewayNames, "gateways", "", "Comma-separated list of gateway names to show, defaults to every gateway")
+	Cmd.Flags().StringVarP(&opts.Output, "output", "o", "table", "Output format, table, json or yaml")
+	Cmd.Flags().StringVar(&opts.TargetVersion, "target-version", opts.TargetVersion, "App version a gateway has to run to be up to date")
+}
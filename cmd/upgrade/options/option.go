@@ -1,16 +1,70 @@
 package options
 
 import (
+	"time"
+
 	"github.com/zhou1203/GatewayUpgradeTool/pkg/options"
 )
 
+// DefaultTargetVersion is the Gateway app version the gateways are upgraded to by default.
+const DefaultTargetVersion = "kubesphere-nginx-ingress-4.12.1"
+
 type RunOptions struct {
 	*options.Options
+	// TargetVersion is the Gateway app version the gateways are upgraded to.
+	TargetVersion      string
 	SpecificAppVersion string
+	// IgnoreDrift upgrades gateways whose helm release no longer matches the Gateway CR.
+	IgnoreDrift bool
+	// Concurrency is the number of gateways upgraded in parallel.
+	Concurrency int
+	// ContinueOnError keeps upgrading the remaining gateways after one failed.
+	ContinueOnError bool
+	// Waves plans the rollout in waves, see upgrade.PlanWaves.
+	Waves string
+	// SoakTime is observed between two waves.
+	SoakTime  time.Duration
+	SmokeTest *SmokeTestOptions
+	// Resume continues the run with this ID from the phases it recorded.
+	Resume string
+	// ReportFile receives a report of the run in ReportFormat, json or markdown.
+	ReportFile   string
+	ReportFormat string
+	Metrics      *MetricsOptions
+	// ForceUnlock takes over the run lock even if another run still holds it.
+	ForceUnlock bool
+}
+
+type SmokeTestOptions struct {
+	// Tests are [scheme://]host[/path][=status] requests sent through the upgraded gateway.
+	Tests []string
+	// FromIngresses adds a test for every host and path of the Ingresses served by the gateway.
+	FromIngresses bool
+	// Via is how the gateway service is reached: clusterip, nodeport or loadbalancer.
+	Via string
+	// Address overrides the host:port all requests are sent to.
+	Address string
+	Timeout time.Duration
+	// Rollback restores the previous gateway spec when a smoke test fails.
+	Rollback bool
+}
+
+type MetricsOptions struct {
+	// BindAddress serves /metrics while the run is going on.
+	BindAddress string
+	// TextfilePath receives the metrics at exit, for the textfile collector of the node exporter.
+	TextfilePath string
+	// PushgatewayURL receives the metrics at exit.
+	PushgatewayURL string
 }
 
 func NewRunOptions() *RunOptions {
 	return &RunOptions{
-		Options: options.NewOptions(),
+		Options:       options.NewOptions(),
+		TargetVersion: DefaultTargetVersion,
+		Concurrency:   1,
+		ReportFormat:  "json",
+		Metrics:       &MetricsOptions{},
+		SmokeTest:     &SmokeTestOptions{Via: "clusterip", Timeout: 10 * time.Second},
 	}
 }
@@ -2,8 +2,10 @@ package upgrade
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
 
 	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
@@ -31,8 +33,36 @@ var Cmd = &cobra.Command{
 
 func init() {
 	Cmd.Flags().StringVar(&opts.KubeConfigPath, "kubeconfig", "", "Path to the kubeconfig file ")
-	Cmd.Flags().StringVar(&opts.GatewayNames, "gateways", "", "Comma-separated list of gateway names to upgrade")
-	Cmd.Flags().StringVar(&opts.SpecificAppVersion, "specific-app-version", "", "App version")
-	Cmd.Flags().BoolVar(&opts.Backup.Enabled, "backup-enabled", false, "Need backup")
-	Cmd.Flags().StringVar(&opts.Backup.Dir, "backup-dir", "/mnt/backup", "Backup directory")
+	AddFlags(Cmd.Flags(), opts)
+}
+
+// AddFlags adds the flags of the upgrade, all but --kubeconfig, to fs. The manifest command shares
+// them to pass the same flags to the upgrade Job.
+func AddFlags(fs *pflag.FlagSet, opts *options.RunOptions) {
+	fs.StringVar(&opts.GatewayNames, "gateways", "", "Comma-separated list of gateway names to upgrade")
+	fs.StringVar(&opts.SpecificAppVersion, "specific-app-version", "", "App version")
+	fs.StringVar(&opts.TargetVersion, "target-version", options.DefaultTargetVersion, "App version the gateways are upgraded to")
+	fs.BoolVar(&opts.IgnoreDrift, "ignore-drift", false, "Upgrade gateways even if their helm release has drifted from the Gateway CR")
+	fs.IntVar(&opts.Concurrency, "concurrency", 1, "Number of gateways upgraded in parallel")
+	fs.BoolVar(&opts.ContinueOnError, "continue-on-error", false, "Keep upgrading the remaining gateways after one failed")
+	fs.StringVar(&opts.Waves, "waves", "", "Upgrade in waves: 'canary' for the first gateway then the rest, 'label' to group by the gateway.kubesphere.io/upgrade-wave label, or waves separated by ';'")
+	fs.DurationVar(&opts.SoakTime, "soak-time", 0, "Time to observe between two waves")
+	fs.StringSliceVar(&opts.SmokeTest.Tests, "smoke-test", nil, "Request sent through each upgraded gateway, [scheme://]host[/path][=status], status defaults to any below 500")
+	fs.BoolVar(&opts.SmokeTest.FromIngresses, "smoke-test-ingresses", false, "Add a smoke test for every host and path of the Ingresses served by the gateway")
+	fs.StringVar(&opts.SmokeTest.Via, "smoke-test-via", "clusterip", "How smoke tests reach the gateway service: clusterip, nodeport or loadbalancer")
+	fs.StringVar(&opts.SmokeTest.Address, "smoke-test-address", "", "Send all smoke test requests to this host:port instead of the gateway service")
+	fs.DurationVar(&opts.SmokeTest.Timeout, "smoke-test-timeout", 10*time.Second, "Timeout of a smoke test request")
+	fs.BoolVar(&opts.SmokeTest.Rollback, "rollback-on-smoke-test-failure", false, "Restore the previous gateway spec when a smoke test fails")
+	fs.DurationVar(&opts.Wait.ReadyTimeout, "ready-timeout", 5*time.Minute, "Time to wait for the resources of a gateway release to become ready")
+	fs.DurationVar(&opts.Wait.SettleDelay, "settle-delay", 5*time.Second, "Time to wait after updating a gateway before checking its release")
+	fs.StringVar(&opts.Resume, "resume", "", "ID of an interrupted run to continue, its gateways replace --gateways")
+	fs.StringVar(&opts.ReportFile, "report-file", "", "Write a report of the run to this file")
+	fs.StringVar(&opts.ReportFormat, "report-format", "json", "Format of the report, json or markdown")
+	fs.StringVar(&opts.Metrics.BindAddress, "metrics-bind-address", "", "Serve Prometheus metrics on this address while running, e.g. :8080")
+	fs.StringVar(&opts.Metrics.TextfilePath, "metrics-textfile", "", "Write Prometheus metrics to this file at exit, for the node exporter textfile collector")
+	fs.StringVar(&opts.Metrics.PushgatewayURL, "metrics-pushgateway", "", "Push Prometheus metrics to this Pushgateway URL at exit")
+	fs.BoolVar(&opts.ForceUnlock, "force-unlock", false, "Take over the run lock held by another run, only use it if that run is gone")
+	fs.BoolVar(&opts.Backup.Enabled, "backup-enabled", false, "Need backup")
+	fs.StringVar(&opts.Backup.Dir, "backup-dir", "/mnt/backup", "Backup directory")
+	fs.StringVar(&opts.Backup.EncryptionKeyFile, "backup-encryption-key-file", "", "File with an age identity, age public key or passphrase used to encrypt the backup")
 }
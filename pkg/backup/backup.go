@@ -0,0 +1,167 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/yaml"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+)
+
+const (
+	FilePrefix      = "gateway-backup-"
+	FileSuffix      = ".yaml"
+	EncryptedSuffix = ".age"
+)
+
+// Write stores the gateways as a multi-document yaml file in dir and returns the file path.
+// If keyFile is set the file is encrypted with it and gets the EncryptedSuffix.
+func Write(dir, keyFile string, gateways []gatewayv2alpha2.Gateway) (string, error) {
+	var key *Key
+	if keyFile != "" {
+		var err error
+		key, err = LoadKey(keyFile)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	fullPath := filepath.Join(dir, fmt.Sprintf("%s%s%s", FilePrefix, time.Now().Format("20060102150405"), FileSuffix))
+	if key != nil {
+		fullPath += EncryptedSuffix
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(fullPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var w io.Writer = file
+	var encrypted io.WriteCloser
+	if key != nil {
+		encrypted, err = key.Encrypt(file)
+		if err != nil {
+			return "", err
+		}
+		w = encrypted
+	}
+
+	for _, gateway := range gateways {
+		marshal, err := yaml.Marshal(gateway)
+		if err != nil {
+			return "", err
+		}
+		_, err = w.Write(marshal)
+		if err != nil {
+			return "", err
+		}
+		_, err = io.WriteString(w, "\n---\n")
+		if err != nil {
+			return "", err
+		}
+		klog.InfoS("Backup gateway", "gateway", klog.KRef(gateway.Namespace, gateway.Name))
+	}
+
+	if encrypted != nil {
+		// The last chunk is only flushed on Close.
+		if err := encrypted.Close(); err != nil {
+			return "", err
+		}
+	}
+	return fullPath, nil
+}
+
+// Read returns the gateways stored in a backup file. Encrypted backups are detected by their
+// content and decrypted with keyFile, plain ones are read as is.
+func Read(path, keyFile string) ([]gatewayv2alpha2.Gateway, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if IsEncrypted(data) {
+		if keyFile == "" {
+			return nil, fmt.Errorf("backup %s is encrypted, a key file is required", path)
+		}
+		key, err := LoadKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		data, err = key.Decrypt(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return decodeGateways(data)
+}
+
+// Verify checks that the backup at path can be read back and holds the expected gateways.
+func Verify(path, keyFile string, gateways []gatewayv2alpha2.Gateway) error {
+	restored, err := Read(path, keyFile)
+	if err != nil {
+		return err
+	}
+	if len(restored) != len(gateways) {
+		return fmt.Errorf("backup %s contains %d gateways, expected %d", path, len(restored), len(gateways))
+	}
+	for i := range gateways {
+		if restored[i].Namespace != gateways[i].Namespace || restored[i].Name != gateways[i].Name {
+			return fmt.Errorf("backup %s contains gateway %s/%s, expected %s/%s", path,
+				restored[i].Namespace, restored[i].Name, gateways[i].Namespace, gateways[i].Name)
+		}
+	}
+	return nil
+}
+
+// Select returns the backed up gateways referenced by refs, in the order of refs.
+func Select(gateways []gatewayv2alpha2.Gateway, refs []*gatewayv2alpha2.GatewayReference) ([]gatewayv2alpha2.Gateway, error) {
+	selected := make([]gatewayv2alpha2.Gateway, 0, len(refs))
+	for _, ref := range refs {
+		namespacedName := ref.ToNamespacedName()
+		found := false
+		for _, gw := range gateways {
+			if gw.Namespace == namespacedName.Namespace && gw.Name == namespacedName.Name {
+				selected = append(selected, gw)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("gateway %s not found in backup", namespacedName)
+		}
+	}
+	return selected, nil
+}
+
+func decodeGateways(data []byte) ([]gatewayv2alpha2.Gateway, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+	var gateways []gatewayv2alpha2.Gateway
+	for {
+		gateway := gatewayv2alpha2.Gateway{}
+		err := decoder.Decode(&gateway)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The writer separates documents with "---" and ends with one, skip the empty tail.
+		if gateway.Name == "" {
+			continue
+		}
+		gateways = append(gateways, gateway)
+	}
+	return gateways, nil
+}
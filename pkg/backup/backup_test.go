@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+)
+
+func testGateways() []gatewayv2alpha2.Gateway {
+	return []gatewayv2alpha2.Gateway{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "gw1"}, Spec: gatewayv2alpha2.GatewaySpec{AppVersion: "kubesphere-nginx-ingress-4.4.0"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: "gw2"}, Spec: gatewayv2alpha2.GatewaySpec{AppVersion: "kubesphere-nginx-ingress-4.4.0"}},
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRoundtrip(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// As written by age-keygen.
+	identityFile := writeFile(t, "key.txt", "# created: 2024-01-01T00:00:00Z\n# public key: "+identity.Recipient().String()+"\n"+identity.String()+"\n")
+	recipientFile := writeFile(t, "key.pub", identity.Recipient().String()+"\n")
+	otherFile := writeFile(t, "other.txt", other.String()+"\n")
+	passphraseFile := writeFile(t, "passphrase", "correct horse battery staple\n")
+
+	tests := []struct {
+		name string
+		// writeKey encrypts the backup, readKey decrypts it.
+		writeKey, readKey string
+		wantErr           string
+	}{
+		{name: "plain"},
+		{name: "identity", writeKey: identityFile, readKey: identityFile},
+		{name: "public key, read with the identity", writeKey: recipientFile, readKey: identityFile},
+		{name: "passphrase", writeKey: passphraseFile, readKey: passphraseFile},
+		{name: "public key only", writeKey: recipientFile, readKey: recipientFile, wantErr: "only contains a public key"},
+		{name: "other identity", writeKey: identityFile, readKey: otherFile, wantErr: "failed to decrypt backup"},
+		{name: "no key", writeKey: identityFile, wantErr: "a key file is required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := Write(t.TempDir(), tt.writeKey, testGateways())
+			if err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			encrypted := tt.writeKey != ""
+			if IsEncrypted(data) != encrypted || strings.HasSuffix(path, EncryptedSuffix) != encrypted {
+				t.Errorf("backup %s encrypted = %t, want %t", path, IsEncrypted(data), encrypted)
+			}
+			if encrypted && bytes.Contains(data, []byte("gw1")) {
+				t.Errorf("encrypted backup contains the gateway names in plain text")
+			}
+
+			err = Verify(path, tt.readKey, testGateways())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Verify() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			gateways, err := Read(path, tt.readKey)
+			if err != nil {
+				t.Fatalf("Read() error = %v", err)
+			}
+			if len(gateways) != 2 || gateways[1].Name != "gw2" || gateways[1].Spec.AppVersion != "kubesphere-nginx-ingress-4.4.0" {
+				t.Errorf("Read() = %+v, want the backed up gateways", gateways)
+			}
+			if err := Verify(path, tt.readKey, testGateways()[:1]); err == nil {
+				t.Errorf("Verify() of a different gateway list succeeded")
+			}
+		})
+	}
+}
+
+func TestDecryptArmored(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parseKey([]byte(identity.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	armored := armor.NewWriter(&buf)
+	w, err := key.Encrypt(armored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, "backup"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := armored.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(buf.Bytes()) {
+		t.Fatalf("armored file is not detected as encrypted")
+	}
+	data, err := key.Decrypt(buf.Bytes())
+	if err != nil || string(data) != "backup" {
+		t.Errorf("Decrypt() = %q, %v, want %q", data, err, "backup")
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name           string
+		content        string
+		wantCanDecrypt bool
+		wantErr        bool
+	}{
+		{name: "empty", content: "# only a comment\n\n", wantErr: true},
+		{name: "invalid identity", content: "AGE-SECRET-KEY-1INVALID\n", wantErr: true},
+		{name: "invalid recipient", content: "age1invalid\n", wantErr: true},
+		{name: "passphrase", content: "secret\r\n", wantCanDecrypt: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := parseKey([]byte(tt.content))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && key.CanDecrypt() != tt.wantCanDecrypt {
+				t.Errorf("CanDecrypt() = %t, want %t", key.CanDecrypt(), tt.wantCanDecrypt)
+			}
+		})
+	}
+}
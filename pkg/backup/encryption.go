@@ -0,0 +1,113 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+const (
+	ageHeader          = "age-encryption.org/v1"
+	ageSecretKeyPrefix = "AGE-SECRET-KEY-1"
+	ageRecipientPrefix = "age1"
+)
+
+// Key is the material read from --backup-encryption-key-file. The file holds either an
+// age X25519 identity (as written by age-keygen), an age X25519 public key, or a passphrase.
+// A public key can only encrypt, so restoring such a backup needs the matching identity file.
+type Key struct {
+	recipient age.Recipient
+	identity  age.Identity
+}
+
+func LoadKey(path string) (*Key, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup encryption key file: %w", err)
+	}
+	return parseKey(content)
+}
+
+func parseKey(content []byte) (*Key, error) {
+	line := firstKeyLine(content)
+	switch {
+	case line == "":
+		return nil, errors.New("backup encryption key file is empty")
+	case strings.HasPrefix(line, ageSecretKeyPrefix):
+		identity, err := age.ParseX25519Identity(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age identity: %w", err)
+		}
+		return &Key{recipient: identity.Recipient(), identity: identity}, nil
+	case strings.HasPrefix(line, ageRecipientPrefix):
+		recipient, err := age.ParseX25519Recipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid age recipient: %w", err)
+		}
+		return &Key{recipient: recipient}, nil
+	default:
+		// Secrets mounted from a file usually end with a newline which is not part of the passphrase.
+		passphrase := strings.TrimRight(string(content), "\r\n")
+		recipient, err := age.NewScryptRecipient(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		return &Key{recipient: recipient, identity: identity}, nil
+	}
+}
+
+// firstKeyLine returns the first line which is neither blank nor a comment, age-keygen
+// prefixes the secret key with "# created" and "# public key" lines.
+func firstKeyLine(content []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		return line
+	}
+	return ""
+}
+
+// CanDecrypt reports whether the key holds a secret, a public key alone cannot read backups back.
+func (k *Key) CanDecrypt() bool {
+	return k.identity != nil
+}
+
+// Encrypt returns a writer encrypting everything written to it into dst, the caller must
+// Close it to flush the last chunk.
+func (k *Key) Encrypt(dst io.Writer) (io.WriteCloser, error) {
+	return age.Encrypt(dst, k.recipient)
+}
+
+func (k *Key) Decrypt(data []byte) ([]byte, error) {
+	if !k.CanDecrypt() {
+		return nil, errors.New("backup is encrypted but the key file only contains a public key")
+	}
+	var src io.Reader = bytes.NewReader(data)
+	if bytes.HasPrefix(data, []byte(armor.Header)) {
+		src = armor.NewReader(src)
+	}
+	r, err := age.Decrypt(src, k.identity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt backup: %w", err)
+	}
+	return io.ReadAll(r)
+}
+
+// IsEncrypted reports whether data is an age file, binary or armored.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageHeader)) || bytes.HasPrefix(data, []byte(armor.Header))
+}
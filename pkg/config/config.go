@@ -0,0 +1,114 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	// EnvPrefix prefixes the environment variable of every flag, --backup-dir is GATEWAY_UPGRADE_BACKUP_DIR.
+	EnvPrefix = "GATEWAY_UPGRADE"
+	// FlagName is the flag naming the config file.
+	FlagName = "config"
+)
+
+// Load fills the flags of cmd which were not set on the command line from the GATEWAY_UPGRADE_*
+// environment variables, then from the config file, whose keys are the flag names:
+//
+//	gateways: gateway-a/ns-a,gateway-b/ns-b
+//	backup-enabled: true
+//	ready-timeout: 10m
+//	smoke-test:
+//	  - example.com/healthz=200
+//
+// Flags keep their defaults if none of them is set. A key of the file which is no flag of any
+// command is rejected, so one file can be shared by the commands but typos don't go unnoticed.
+func Load(cmd *cobra.Command, file string) error {
+	v := viper.New()
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+	v.AutomaticEnv()
+
+	if file == "" {
+		file = v.GetString(FlagName)
+	}
+	if file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read config file %s, %v", file, err)
+		}
+		if unknown := unknownKeys(cmd.Root(), v.AllKeys()); len(unknown) > 0 {
+			return fmt.Errorf("invalid config file %s, unknown keys %s", file, strings.Join(unknown, ", "))
+		}
+	}
+
+	var errs []string
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if f.Changed || f.Name == FlagName || !v.IsSet(f.Name) {
+			return
+		}
+		if err := set(f, v); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", f.Name, err))
+		}
+	})
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config, %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func set(f *pflag.Flag, v *viper.Viper) error {
+	if s, ok := f.Value.(pflag.SliceValue); ok {
+		// A comma-separated string in the environment, a list or a single item in the file. Items of
+		// the file are kept as they are, they may contain commas.
+		var items []string
+		if env := os.Getenv(envName(f.Name)); env != "" {
+			for _, item := range strings.Split(env, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+		} else if item, ok := v.Get(f.Name).(string); ok {
+			items = []string{item}
+		} else {
+			items = v.GetStringSlice(f.Name)
+		}
+		return s.Replace(items)
+	}
+	return f.Value.Set(v.GetString(f.Name))
+}
+
+// envName is the environment variable of the flag, e.g. GATEWAY_UPGRADE_BACKUP_DIR.
+func envName(flag string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// unknownKeys returns the keys which are no flag of root or any of its subcommands, sorted.
+func unknownKeys(root *cobra.Command, keys []string) []string {
+	flags := map[string]bool{}
+	var visit func(cmd *cobra.Command)
+	visit = func(cmd *cobra.Command) {
+		add := func(f *pflag.Flag) { flags[f.Name] = true }
+		cmd.Flags().VisitAll(add)
+		cmd.PersistentFlags().VisitAll(add)
+		for _, sub := range cmd.Commands() {
+			visit(sub)
+		}
+	}
+	visit(root)
+
+	var unknown []string
+	for _, key := range keys {
+		if !flags[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
@@ -0,0 +1,131 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type testOptions struct {
+	gateways     string
+	smokeTests   []string
+	readyTimeout time.Duration
+	concurrency  int
+}
+
+// testCommand returns a root command with an upgrade subcommand, and a status subcommand whose
+// --output flag is no flag of upgrade.
+func testCommand() (*cobra.Command, *testOptions) {
+	opts := &testOptions{}
+	root := &cobra.Command{Use: "root"}
+	root.PersistentFlags().String(FlagName, "", "")
+	upgrade := &cobra.Command{Use: "upgrade"}
+	upgrade.Flags().StringVar(&opts.gateways, "gateways", "", "")
+	upgrade.Flags().StringSliceVar(&opts.smokeTests, "smoke-test", nil, "")
+	upgrade.Flags().DurationVar(&opts.readyTimeout, "ready-timeout", 5*time.Minute, "")
+	upgrade.Flags().IntVar(&opts.concurrency, "concurrency", 1, "")
+	status := &cobra.Command{Use: "status"}
+	status.Flags().String("output", "table", "")
+	root.AddCommand(upgrade, status)
+	return upgrade, opts
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		want    testOptions
+		wantErr string
+	}{
+		{
+			name: "defaults",
+			want: testOptions{readyTimeout: 5 * time.Minute, concurrency: 1},
+		},
+		{
+			name: "file",
+			file: "gateways: a/ns-a,b/ns-b\nready-timeout: 10m\nconcurrency: 2\n",
+			want: testOptions{gateways: "a/ns-a,b/ns-b", readyTimeout: 10 * time.Minute, concurrency: 2},
+		},
+		{
+			name: "file list items keep their commas",
+			file: "smoke-test:\n  - example.com/search?q=a,b=200\n  - example.com/healthz\n",
+			want: testOptions{smokeTests: []string{"example.com/search?q=a,b=200", "example.com/healthz"}, readyTimeout: 5 * time.Minute, concurrency: 1},
+		},
+		{
+			name: "file string is a single item",
+			file: "smoke-test: example.com/search?q=a,b\n",
+			want: testOptions{smokeTests: []string{"example.com/search?q=a,b"}, readyTimeout: 5 * time.Minute, concurrency: 1},
+		},
+		{
+			name: "environment list is comma-separated",
+			env:  map[string]string{"GATEWAY_UPGRADE_SMOKE_TEST": "example.com/a, example.com/b"},
+			want: testOptions{smokeTests: []string{"example.com/a", "example.com/b"}, readyTimeout: 5 * time.Minute, concurrency: 1},
+		},
+		{
+			name: "environment takes precedence over the file",
+			file: "concurrency: 2\nsmoke-test:\n  - example.com/file\n",
+			env:  map[string]string{"GATEWAY_UPGRADE_CONCURRENCY": "3", "GATEWAY_UPGRADE_SMOKE_TEST": "example.com/env"},
+			want: testOptions{smokeTests: []string{"example.com/env"}, readyTimeout: 5 * time.Minute, concurrency: 3},
+		},
+		{
+			name: "command line takes precedence over the environment",
+			env:  map[string]string{"GATEWAY_UPGRADE_CONCURRENCY": "3"},
+			args: []string{"--concurrency=4"},
+			want: testOptions{readyTimeout: 5 * time.Minute, concurrency: 4},
+		},
+		{
+			name: "flag of another command",
+			file: "output: json\nconcurrency: 2\n",
+			want: testOptions{readyTimeout: 5 * time.Minute, concurrency: 2},
+		},
+		{
+			name:    "unknown keys",
+			file:    "concurency: 2\nbackup:\n  dir: /backups\n",
+			wantErr: "unknown keys backup.dir, concurency",
+		},
+		{
+			name:    "invalid value",
+			file:    "ready-timeout: soon\n",
+			wantErr: "ready-timeout",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			var file string
+			if tt.file != "" {
+				file = filepath.Join(t.TempDir(), "upgrade.yaml")
+				if err := os.WriteFile(file, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cmd, opts := testCommand()
+			if err := cmd.Flags().Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Load(cmd, file)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if !reflect.DeepEqual(*opts, tt.want) {
+				t.Errorf("Load() options = %+v, want %+v", *opts, tt.want)
+			}
+		})
+	}
+}
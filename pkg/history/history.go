@@ -0,0 +1,243 @@
+package history
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// ConfigMapName is the ConfigMap holding the ledger.
+	ConfigMapName = "gateway-upgrade-history"
+	ConfigMapKey  = "history.yaml"
+	// MaxEntries bounds the ledger so it stays well below the size limit of a ConfigMap, the
+	// oldest entries are dropped first.
+	MaxEntries = 1000
+	// MaxFieldLength bounds Reason and Command of an entry, a long error or command line would
+	// otherwise push MaxEntries entries past the limit.
+	MaxFieldLength = 256
+	// MaxSize bounds the encoded ledger below the 1 MiB limit of a ConfigMap, the oldest entries
+	// are dropped until it fits.
+	MaxSize = 900 * 1024
+
+	OperationUpgrade  = "upgrade"
+	OperationRollback = "rollback"
+
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+
+	FormatTable = "table"
+	FormatJSON  = "json"
+)
+
+// Entry records one change of one gateway.
+type Entry struct {
+	Time      metav1.Time `json:"time"`
+	Operation string      `json:"operation"`
+	// RunID is the upgrade run, see upgrade.Checkpoint.
+	RunID string `json:"runID,omitempty"`
+	// User is the Kubernetes user the tool ran as, Host the machine or pod it ran on.
+	User    string `json:"user,omitempty"`
+	Host    string `json:"host,omitempty"`
+	Command string `json:"command,omitempty"`
+	// Gateway is namespace/name.
+	Gateway     string `json:"gateway"`
+	FromVersion string `json:"fromVersion,omitempty"`
+	ToVersion   string `json:"toVersion,omitempty"`
+	// ValuesHash identifies the values the gateway ended up with, see ValuesHash.
+	ValuesHash string          `json:"valuesHash,omitempty"`
+	Backup     string          `json:"backup,omitempty"`
+	Outcome    string          `json:"outcome"`
+	Reason     string          `json:"reason,omitempty"`
+	Duration   metav1.Duration `json:"duration"`
+}
+
+// Ledger appends entries to a ConfigMap, it is shared by every run against the cluster.
+type Ledger struct {
+	client    client.Client
+	namespace string
+}
+
+func New(c client.Client, namespace string) *Ledger {
+	return &Ledger{client: c, namespace: namespace}
+}
+
+// Append adds entries to the ledger, retrying if another run appended at the same time.
+func (l *Ledger) Append(ctx context.Context, entries ...Entry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		configMap := &corev1.ConfigMap{}
+		err := l.client.Get(ctx, types.NamespacedName{Namespace: l.namespace, Name: ConfigMapName}, configMap)
+		if apierrors.IsNotFound(err) {
+			configMap = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: l.namespace, Name: ConfigMapName}}
+		} else if err != nil {
+			return err
+		}
+		ledger, err := decode(configMap)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			entry.Reason = truncate(entry.Reason, MaxFieldLength)
+			entry.Command = truncate(entry.Command, MaxFieldLength)
+			ledger = append(ledger, entry)
+		}
+		if len(ledger) > MaxEntries {
+			ledger = ledger[len(ledger)-MaxEntries:]
+		}
+		data, err := yaml.Marshal(ledger)
+		for err == nil && len(data) > MaxSize && len(ledger) > 1 {
+			ledger = ledger[len(ledger)/10+1:]
+			data, err = yaml.Marshal(ledger)
+		}
+		if err != nil {
+			return err
+		}
+		configMap.Data = map[string]string{ConfigMapKey: string(data)}
+		if configMap.ResourceVersion == "" {
+			err = l.client.Create(ctx, configMap)
+			if apierrors.IsAlreadyExists(err) {
+				// Created by another run in the meantime, retry as a conflict.
+				return apierrors.NewConflict(corev1.Resource("configmaps"), ConfigMapName, err)
+			}
+			return err
+		}
+		return l.client.Update(ctx, configMap)
+	})
+}
+
+// List returns all entries, oldest first.
+func (l *Ledger) List(ctx context.Context) ([]Entry, error) {
+	configMap := &corev1.ConfigMap{}
+	err := l.client.Get(ctx, types.NamespacedName{Namespace: l.namespace, Name: ConfigMapName}, configMap)
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decode(configMap)
+}
+
+func decode(configMap *corev1.ConfigMap) ([]Entry, error) {
+	var entries []Entry
+	err := yaml.Unmarshal([]byte(configMap.Data[ConfigMapKey]), &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s/%s: %w", configMap.Namespace, configMap.Name, err)
+	}
+	return entries, nil
+}
+
+// NewEntry returns an entry for the change of the gateway which started at start and took
+// duration, stamped with its end and the host and command line of the tool.
+func NewEntry(operation, gateway string, start time.Time, duration time.Duration) Entry {
+	host, _ := os.Hostname()
+	return Entry{
+		Time:      metav1.NewTime(start.Add(duration)),
+		Operation: operation,
+		Host:      host,
+		Command:   strings.Join(os.Args, " "),
+		Gateway:   gateway,
+		Duration:  metav1.Duration{Duration: duration.Round(time.Second)},
+	}
+}
+
+// User returns the user the client authenticates as, empty if the cluster does not support
+// SelfSubjectReview.
+func User(ctx context.Context, c client.Client) string {
+	review := &authenticationv1.SelfSubjectReview{}
+	err := c.Create(ctx, review)
+	if err != nil {
+		return ""
+	}
+	return review.Status.UserInfo.Username
+}
+
+// ValuesHash returns a short sha256 of the gateway values, equal values give equal hashes.
+func ValuesHash(values []byte) string {
+	if len(values) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(values)
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// Filter returns the entries of the gateways, all entries if gateways is empty.
+func Filter(entries []Entry, gateways []string) []Entry {
+	if len(gateways) == 0 {
+		return entries
+	}
+	selected := map[string]bool{}
+	for _, gateway := range gateways {
+		selected[gateway] = true
+	}
+	var filtered []Entry
+	for _, entry := range entries {
+		if selected[entry.Gateway] {
+			filtered = append(filtered, entry)
+		}
+	}
+	return filtered
+}
+
+func Print(out io.Writer, entries []Entry, format string) error {
+	switch format {
+	case FormatJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if entries == nil {
+			entries = []Entry{}
+		}
+		return encoder.Encode(entries)
+	case FormatTable, "":
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tOPERATION\tGATEWAY\tFROM\tTO\tVALUES\tOUTCOME\tDURATION\tUSER\tBACKUP")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				entry.Time.Format(time.RFC3339), entry.Operation, entry.Gateway, dash(entry.FromVersion), dash(entry.ToVersion),
+				dash(entry.ValuesHash), entry.Outcome, entry.Duration.Duration, dash(entry.User), dash(entry.Backup))
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown output format %q, use %s or %s", format, FormatTable, FormatJSON)
+	}
+}
+
+// truncate cuts s to at most n bytes, marking the cut with "...". It doesn't split a UTF-8
+// character.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	cut := n - len("...")
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "..."
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,72 @@
+package history
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{in: "short", n: 10, want: "short"},
+		{in: "exactly10!", n: 10, want: "exactly10!"},
+		{in: "a longer reason", n: 10, want: "a longe..."},
+		{in: "ab€€", n: 7, want: "ab..."},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.in, tt.n)
+		if got != tt.want || len(got) > tt.n || !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.in, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestAppendBoundsLedger(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	ledger := New(c, "ns")
+
+	var entries []Entry
+	for i := 0; i < MaxEntries+10; i++ {
+		entry := NewEntry(OperationUpgrade, "ns/gw", time.Now(), time.Minute)
+		entry.Outcome = OutcomeFailed
+		entry.Reason = strings.Repeat("r", 10*MaxFieldLength)
+		entry.Command = strings.Repeat("c", 10*MaxFieldLength)
+		entries = append(entries, entry)
+	}
+	if err := ledger.Append(ctx, entries...); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ledger.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 || len(got) > MaxEntries {
+		t.Errorf("ledger has %d entries, want 1 to %d", len(got), MaxEntries)
+	}
+	for _, entry := range got {
+		if len(entry.Reason) > MaxFieldLength || len(entry.Command) > MaxFieldLength {
+			t.Fatalf("entry has a reason of %d and a command of %d bytes, want at most %d", len(entry.Reason), len(entry.Command), MaxFieldLength)
+		}
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "ns", Name: ConfigMapName}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	if size := len(configMap.Data[ConfigMapKey]); size > MaxSize {
+		t.Errorf("ledger is %d bytes, want at most %d", size, MaxSize)
+	}
+}
@@ -0,0 +1,30 @@
+package kubeclient
+
+import (
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
+)
+
+// RESTConfig loads the config from kubeconfig, or the in-cluster config when it is empty.
+func RESTConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig == "" {
+		return rest.InClusterConfig()
+	}
+	return clientcmd.BuildConfigFromFlags("", kubeconfig)
+}
+
+func New(kubeconfig string) (client.Client, error) {
+	config, err := RESTConfig(kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewForConfig(config)
+}
+
+func NewForConfig(config *rest.Config) (client.Client, error) {
+	return client.New(config, client.Options{Scheme: scheme.Scheme})
+}
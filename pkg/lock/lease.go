@@ -0,0 +1,200 @@
+package lock
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/logging"
+)
+
+const (
+	// LeaseName is the Lease held while gateways are changed.
+	LeaseName = "gateway-upgrade-tool"
+	// LeaseDuration is how long a lease stays valid without being renewed.
+	LeaseDuration = 60 * time.Second
+)
+
+// Lock is a cluster-wide lock backed by a coordination.k8s.io/v1 Lease, so two runs never change
+// gateways at the same time.
+type Lock struct {
+	client    client.Client
+	namespace string
+	name      string
+	identity  string
+	// renewInterval is how often the lease is renewed, a third of LeaseDuration.
+	renewInterval time.Duration
+
+	mu     sync.Mutex
+	lease  *coordinationv1.Lease
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(c client.Client, namespace string) *Lock {
+	return &Lock{client: c, namespace: namespace, name: LeaseName, identity: Identity(), renewInterval: LeaseDuration / 3}
+}
+
+// Identity names the holder after the host, which is the pod name when run as a Job, and a random
+// suffix so two runs on the same host are told apart.
+func Identity() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s_%s", hostname, uuid.NewUUID())
+}
+
+// Acquire takes the lease and renews it until Release is called. A lease held by another run is
+// only taken over if it expired or force is set. The returned context is cancelled if the lease
+// is lost while running.
+func (l *Lock) Acquire(ctx context.Context, force bool) (context.Context, error) {
+	now := metav1.NewMicroTime(time.Now())
+	lease := &coordinationv1.Lease{}
+	err := l.client.Get(ctx, types.NamespacedName{Namespace: l.namespace, Name: l.name}, lease)
+	switch {
+	case apierrors.IsNotFound(err):
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Namespace: l.namespace, Name: l.name},
+			Spec:       l.spec(now),
+		}
+		err = l.client.Create(ctx, lease)
+	case err != nil:
+		return nil, err
+	default:
+		holder := ptr.Deref(lease.Spec.HolderIdentity, "")
+		if holder != "" && !expired(lease) {
+			if !force {
+				return nil, fmt.Errorf("lease %s/%s is held by %s, renewed at %s; use --force-unlock if it is stale",
+					l.namespace, l.name, holder, renewTime(lease))
+			}
+			logging.WarningS("Force unlock lease", "namespace", l.namespace, "lease", l.name, "holder", holder)
+		} else if holder != "" {
+			logging.WarningS("Lease expired, take it over", "namespace", l.namespace, "lease", l.name, "holder", holder)
+		}
+		transitions := ptr.Deref(lease.Spec.LeaseTransitions, 0) + 1
+		lease.Spec = l.spec(now)
+		lease.Spec.LeaseTransitions = &transitions
+		// The resourceVersion makes a concurrent takeover fail with a conflict.
+		err = l.client.Update(ctx, lease)
+	}
+	if apierrors.IsAlreadyExists(err) || apierrors.IsConflict(err) {
+		return nil, fmt.Errorf("lease %s/%s was acquired by another run, try again later", l.namespace, l.name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lease %s/%s: %w", l.namespace, l.name, err)
+	}
+	klog.InfoS("Acquired lease", "namespace", l.namespace, "lease", l.name, "identity", l.identity)
+
+	l.lease = lease
+	heldCtx, cancel := context.WithCancel(ctx)
+	l.cancel = cancel
+	l.done = make(chan struct{})
+	go l.renew(heldCtx, cancel)
+	return heldCtx, nil
+}
+
+func (l *Lock) spec(now metav1.MicroTime) coordinationv1.LeaseSpec {
+	return coordinationv1.LeaseSpec{
+		HolderIdentity:       ptr.To(l.identity),
+		LeaseDurationSeconds: ptr.To(int32(LeaseDuration.Seconds())),
+		AcquireTime:          &now,
+		RenewTime:            &now,
+	}
+}
+
+// renew updates the renew time every third of the lease duration and cancels the run once the
+// lease could not be renewed before it expired or was taken over.
+func (l *Lock) renew(ctx context.Context, cancel context.CancelFunc) {
+	defer close(l.done)
+	ticker := time.NewTicker(l.renewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		l.mu.Lock()
+		lease := l.lease.DeepCopy()
+		lease.Spec.RenewTime = ptr.To(metav1.NewMicroTime(time.Now()))
+		err := l.client.Update(ctx, lease)
+		if err == nil {
+			l.lease = lease
+		}
+		renewed := l.lease.Spec.RenewTime.Time
+		l.mu.Unlock()
+		if err == nil {
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		klog.ErrorS(err, "Failed to renew lease", "namespace", l.namespace, "lease", l.name)
+		if apierrors.IsConflict(err) || time.Since(renewed) > LeaseDuration {
+			klog.ErrorS(nil, "Lost lease, stop the run", "namespace", l.namespace, "lease", l.name)
+			cancel()
+			return
+		}
+	}
+}
+
+// Release stops renewing and clears the holder so the next run does not have to wait for the
+// lease to expire. It also runs after ctx of Acquire was cancelled, e.g. on SIGTERM.
+func (l *Lock) Release() {
+	if l.cancel == nil {
+		return
+	}
+	l.cancel()
+	<-l.done
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	lease := &coordinationv1.Lease{}
+	err := l.client.Get(ctx, types.NamespacedName{Namespace: l.namespace, Name: l.name}, lease)
+	if err != nil {
+		klog.ErrorS(err, "Failed to release lease", "namespace", l.namespace, "lease", l.name)
+		return
+	}
+	if ptr.Deref(lease.Spec.HolderIdentity, "") != l.identity {
+		logging.WarningS("Lease is held by another run, leave it", "namespace", l.namespace, "lease", l.name, "holder", ptr.Deref(lease.Spec.HolderIdentity, ""))
+		return
+	}
+	lease.Spec.HolderIdentity = nil
+	lease.Spec.AcquireTime = nil
+	lease.Spec.RenewTime = nil
+	err = l.client.Update(ctx, lease)
+	if err != nil {
+		klog.ErrorS(err, "Failed to release lease", "namespace", l.namespace, "lease", l.name)
+		return
+	}
+	klog.InfoS("Released lease", "namespace", l.namespace, "lease", l.name)
+}
+
+func expired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil {
+		return true
+	}
+	duration := time.Duration(ptr.Deref(lease.Spec.LeaseDurationSeconds, int32(LeaseDuration.Seconds()))) * time.Second
+	return time.Since(lease.Spec.RenewTime.Time) > duration
+}
+
+func renewTime(lease *coordinationv1.Lease) string {
+	if lease.Spec.RenewTime == nil {
+		return "unknown"
+	}
+	return lease.Spec.RenewTime.Format(time.RFC3339)
+}
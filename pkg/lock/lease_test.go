@@ -0,0 +1,138 @@
+package lock
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
+)
+
+const testNamespace = "extension-gateway"
+
+func testLease(holder string, renewed time.Time) *coordinationv1.Lease {
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Namespace: testNamespace, Name: LeaseName},
+		Spec: coordinationv1.LeaseSpec{
+			LeaseDurationSeconds: ptr.To(int32(LeaseDuration.Seconds())),
+			LeaseTransitions:     ptr.To(int32(1)),
+		},
+	}
+	if holder != "" {
+		lease.Spec.HolderIdentity = ptr.To(holder)
+		lease.Spec.RenewTime = ptr.To(metav1.NewMicroTime(renewed))
+	}
+	return lease
+}
+
+func getLease(t *testing.T, c client.Client) *coordinationv1.Lease {
+	t.Helper()
+	lease := &coordinationv1.Lease{}
+	if err := c.Get(context.Background(), types.NamespacedName{Namespace: testNamespace, Name: LeaseName}, lease); err != nil {
+		t.Fatal(err)
+	}
+	return lease
+}
+
+func TestAcquire(t *testing.T) {
+	tests := []struct {
+		name            string
+		existing        *coordinationv1.Lease
+		force           bool
+		wantErr         string
+		wantTransitions int32
+	}{
+		{name: "no lease"},
+		{name: "released lease", existing: testLease("", time.Time{}), wantTransitions: 2},
+		{name: "held by another run", existing: testLease("other", time.Now()), wantErr: "is held by other"},
+		{name: "force unlock", existing: testLease("other", time.Now()), force: true, wantTransitions: 2},
+		{name: "expired lease", existing: testLease("other", time.Now().Add(-2*LeaseDuration)), wantTransitions: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder().WithScheme(scheme.Scheme)
+			if tt.existing != nil {
+				builder = builder.WithObjects(tt.existing)
+			}
+			c := builder.Build()
+			l := New(c, testNamespace)
+
+			ctx, err := l.Acquire(context.Background(), tt.force)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Acquire() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				if holder := ptr.Deref(getLease(t, c).Spec.HolderIdentity, ""); holder != "other" {
+					t.Errorf("holder = %q, want the lease left to other", holder)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Acquire() error = %v", err)
+			}
+			defer l.Release()
+			if ctx.Err() != nil {
+				t.Errorf("context of the acquired lease is done: %v", ctx.Err())
+			}
+			lease := getLease(t, c)
+			if holder := ptr.Deref(lease.Spec.HolderIdentity, ""); holder != l.identity {
+				t.Errorf("holder = %q, want %q", holder, l.identity)
+			}
+			if transitions := ptr.Deref(lease.Spec.LeaseTransitions, 0); transitions != tt.wantTransitions {
+				t.Errorf("lease transitions = %d, want %d", transitions, tt.wantTransitions)
+			}
+		})
+	}
+}
+
+func TestRelease(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	l := New(c, testNamespace)
+	if _, err := l.Acquire(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	l.Release()
+	if lease := getLease(t, c); lease.Spec.HolderIdentity != nil || lease.Spec.RenewTime != nil {
+		t.Errorf("released lease = %+v, want no holder", lease.Spec)
+	}
+	// The next run does not have to wait for the lease to expire.
+	next := New(c, testNamespace)
+	if _, err := next.Acquire(context.Background(), false); err != nil {
+		t.Fatalf("Acquire() after Release() error = %v", err)
+	}
+	next.Release()
+}
+
+func TestLostLease(t *testing.T) {
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	l := New(c, testNamespace)
+	l.renewInterval = 10 * time.Millisecond
+	ctx, err := l.Acquire(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := New(c, testNamespace)
+	if _, err := other.Acquire(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	defer other.Release()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context was not cancelled after the lease was taken over")
+	}
+	// Releasing the lost lease leaves it to the run which took it over.
+	l.Release()
+	if holder := ptr.Deref(getLease(t, c).Spec.HolderIdentity, ""); holder != other.identity {
+		t.Errorf("holder after Release() of the lost lease = %q, want %q", holder, other.identity)
+	}
+}
@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// jsonFormat is set once Setup switched klog to json.
+var jsonFormat bool
+
+// Setup switches klog to format. With json every line, Infof ones included, is a JSON object on
+// stderr carrying the key/value pairs of the structured calls.
+func Setup(format string) error {
+	switch format {
+	case FormatText, "":
+		return nil
+	case FormatJSON:
+		logger := funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(os.Stderr, obj)
+		}, funcr.Options{
+			LogTimestamp: true,
+			LogCaller:    funcr.All,
+			// klog filters by its own -v before calling the logger.
+			Verbosity: 10,
+		})
+		klog.SetLogger(logger)
+		jsonFormat = true
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q, use %s or %s", format, FormatText, FormatJSON)
+	}
+}
+
+// WarningS logs a structured message at warning severity, klog has no structured warning. A json
+// line carries severity=warning, since klog passes warnings to the logger as info.
+func WarningS(msg string, keysAndValues ...interface{}) {
+	if jsonFormat {
+		klog.InfoSDepth(1, msg, append([]interface{}{"severity", "warning"}, keysAndValues...)...)
+		return
+	}
+	klog.WarningDepth(1, formatText(msg, keysAndValues))
+}
+
+// formatText formats the message like a klog text line of InfoS, "msg" key="value".
+func formatText(msg string, keysAndValues []interface{}) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%q", msg)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		var value interface{} = "(MISSING)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		switch v := value.(type) {
+		case string:
+			fmt.Fprintf(&b, " %v=%q", keysAndValues[i], v)
+		case error:
+			fmt.Fprintf(&b, " %v=%q", keysAndValues[i], v.Error())
+		case fmt.Stringer:
+			fmt.Fprintf(&b, " %v=%q", keysAndValues[i], v.String())
+		default:
+			fmt.Fprintf(&b, " %v=%v", keysAndValues[i], v)
+		}
+	}
+	return b.String()
+}
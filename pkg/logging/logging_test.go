@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFormatText(t *testing.T) {
+	tests := []struct {
+		msg           string
+		keysAndValues []interface{}
+		want          string
+	}{
+		{msg: "Skip gateway", want: `"Skip gateway"`},
+		{
+			msg:           "Skip gateway",
+			keysAndValues: []interface{}{"namespace", "ns", "gateway", "gw", "wave", 2},
+			want:          `"Skip gateway" namespace="ns" gateway="gw" wave=2`,
+		},
+		{
+			msg:           "Failed",
+			keysAndValues: []interface{}{"err", errors.New("boom"), "soakTime", time.Second},
+			want:          `"Failed" err="boom" soakTime="1s"`,
+		},
+		{msg: "Odd", keysAndValues: []interface{}{"key"}, want: `"Odd" key="(MISSING)"`},
+	}
+	for _, tt := range tests {
+		if got := formatText(tt.msg, tt.keysAndValues); got != tt.want {
+			t.Errorf("formatText() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestSetup(t *testing.T) {
+	if err := Setup("yaml"); err == nil {
+		t.Error("Setup(yaml) succeeded, want an error")
+	}
+	if err := Setup(FormatText); err != nil || jsonFormat {
+		t.Errorf("Setup(text) = %v, json %t", err, jsonFormat)
+	}
+}
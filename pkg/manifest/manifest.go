@@ -0,0 +1,134 @@
+package manifest
+
+import (
+	"fmt"
+	"io"
+	"path"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/manifest/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/config"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
+)
+
+const (
+	JobName = "gateway-upgrade-job"
+	PVCName = "gateway-upgrade-pvc"
+	// ConfigDir is where the ConfigMap of ManifestOptions.ConfigMap is mounted.
+	ConfigDir  = "/etc/gateway-upgrade-tool"
+	ConfigFile = "upgrade.yaml"
+)
+
+// Objects returns the objects running the upgrade as a Job: the ServiceAccount and its RBAC if
+// requested, the PVC of the backups if they are enabled, and the Job.
+func Objects(opts *options.ManifestOptions) ([]runtime.Object, error) {
+	var objects []runtime.Object
+	if opts.CreateRBAC {
+		clusterRole := upgrade.NewClusterRole(upgrade.JobPermissions(opts.Upgrade))
+		objects = append(objects,
+			&corev1.ServiceAccount{
+				TypeMeta:   metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "ServiceAccount"},
+				ObjectMeta: metav1.ObjectMeta{Name: opts.ServiceAccount, Namespace: opts.Namespace},
+			},
+			clusterRole,
+			&rbacv1.ClusterRoleBinding{
+				TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRoleBinding"},
+				ObjectMeta: metav1.ObjectMeta{Name: clusterRole.Name},
+				RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: clusterRole.Name},
+				Subjects:   []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: opts.ServiceAccount, Namespace: opts.Namespace}},
+			})
+	}
+
+	args := append([]string{"upgrade"}, opts.Args...)
+	container := corev1.Container{
+		Name:            "upgrade-tool",
+		Image:           opts.Image,
+		ImagePullPolicy: corev1.PullAlways,
+	}
+	podSpec := corev1.PodSpec{
+		RestartPolicy:      corev1.RestartPolicyNever,
+		ServiceAccountName: opts.ServiceAccount,
+	}
+
+	if opts.Upgrade.Backup.Enabled {
+		size, err := resource.ParseQuantity(opts.StorageSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage size %q, %v", opts.StorageSize, err)
+		}
+		pvc := &corev1.PersistentVolumeClaim{
+			TypeMeta:   metav1.TypeMeta{APIVersion: corev1.SchemeGroupVersion.String(), Kind: "PersistentVolumeClaim"},
+			ObjectMeta: metav1.ObjectMeta{Name: PVCName, Namespace: opts.Namespace},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				Resources:   corev1.VolumeResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: size}},
+			},
+		}
+		if opts.StorageClass != "" {
+			pvc.Spec.StorageClassName = ptr.To(opts.StorageClass)
+		}
+		objects = append(objects, pvc)
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "backup-volume", MountPath: opts.Upgrade.Backup.Dir})
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name:         "backup-volume",
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: PVCName}},
+		})
+	}
+
+	if opts.ConfigMap != "" {
+		args = append(args, fmt.Sprintf("--%s=%s", config.FlagName, path.Join(ConfigDir, ConfigFile)))
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{Name: "config", MountPath: ConfigDir, ReadOnly: true})
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{Name: opts.ConfigMap},
+			}},
+		})
+	}
+
+	container.Args = args
+	podSpec.Containers = []corev1.Container{container}
+	objects = append(objects, &batchv1.Job{
+		TypeMeta:   metav1.TypeMeta{APIVersion: batchv1.SchemeGroupVersion.String(), Kind: "Job"},
+		ObjectMeta: metav1.ObjectMeta{Name: JobName, Namespace: opts.Namespace},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: ptr.To[int32](0),
+			Template:     corev1.PodTemplateSpec{Spec: podSpec},
+		},
+	})
+	return objects, nil
+}
+
+// Write writes the objects to out as a multi-document YAML stream.
+func Write(out io.Writer, objects []runtime.Object) error {
+	for i, object := range objects {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(object)
+		if err != nil {
+			return err
+		}
+		unstructured.RemoveNestedField(obj, "metadata", "creationTimestamp")
+		unstructured.RemoveNestedField(obj, "spec", "template", "metadata")
+		unstructured.RemoveNestedField(obj, "status")
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if _, err := fmt.Fprintln(out, "---"); err != nil {
+				return err
+			}
+		}
+		if _, err := out.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package manifest
+
+import (
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/manifest/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
+)
+
+func TestObjects(t *testing.T) {
+	tests := []struct {
+		name          string
+		configure     func(opts *options.ManifestOptions)
+		wantResources []string
+		notResources  []string
+		wantVolumes   int
+	}{
+		{
+			name:         "defaults",
+			configure:    func(opts *options.ManifestOptions) {},
+			notResources: []string{"nodes", "ingresses"},
+		},
+		{
+			name: "smoke tests via node port from ingresses",
+			configure: func(opts *options.ManifestOptions) {
+				opts.Upgrade.SmokeTest.FromIngresses = true
+				opts.Upgrade.SmokeTest.Via = upgrade.SmokeTestViaNodePort
+			},
+			wantResources: []string{"nodes", "ingresses"},
+		},
+		{
+			name: "backup and config map",
+			configure: func(opts *options.ManifestOptions) {
+				opts.Upgrade.Backup.Enabled = true
+				opts.Upgrade.Backup.Dir = "/mnt/backup"
+				opts.ConfigMap = "upgrade-config"
+			},
+			wantVolumes: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := options.NewManifestOptions()
+			tt.configure(opts)
+			objects, err := Objects(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resources := map[string]bool{}
+			var job *batchv1.Job
+			for _, object := range objects {
+				switch o := object.(type) {
+				case *rbacv1.ClusterRole:
+					for _, rule := range o.Rules {
+						for _, resource := range rule.Resources {
+							resources[resource] = true
+						}
+					}
+				case *batchv1.Job:
+					job = o
+				}
+			}
+			for _, resource := range tt.wantResources {
+				if !resources[resource] {
+					t.Errorf("ClusterRole misses %s", resource)
+				}
+			}
+			for _, resource := range tt.notResources {
+				if resources[resource] {
+					t.Errorf("ClusterRole grants %s", resource)
+				}
+			}
+			if job == nil {
+				t.Fatal("no Job")
+			}
+			if got := len(job.Spec.Template.Spec.Volumes); got != tt.wantVolumes {
+				t.Errorf("Job has %d volumes, want %d", got, tt.wantVolumes)
+			}
+		})
+	}
+}
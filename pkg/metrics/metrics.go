@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"k8s.io/klog/v2"
+)
+
+const (
+	namespace = "gateway_upgrade"
+	// PushJob is the job label of the metrics pushed to a Pushgateway.
+	PushJob = "gateway_upgrade_tool"
+)
+
+var (
+	// Registry holds the metrics of the run only, without the Go runtime collectors, so a textfile
+	// or a push does not clash with the metrics of the node exporter or other jobs.
+	Registry = prometheus.NewRegistry()
+
+	gateways = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "gateways",
+		Help:      "Number of gateways selected for the run.",
+	})
+	gatewaysFinished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "gateways_total",
+		Help:      "Number of gateways handled by the run, by outcome.",
+	}, []string{"outcome"})
+	gatewayDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "gateway_duration_seconds",
+		Help:      "Time taken to upgrade a gateway, by outcome.",
+		Buckets:   []float64{10, 30, 60, 120, 300, 600, 1200},
+	}, []string{"outcome"})
+	readyWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ready_wait_seconds",
+		Help:      "Time spent waiting for a gateway release to be reconciled and ready.",
+		Buckets:   []float64{5, 10, 30, 60, 120, 300, 600},
+	})
+	runDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the run.",
+	})
+	runSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "run_success",
+		Help:      "1 if the run finished without error, 0 otherwise.",
+	})
+	runTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "run_last_timestamp_seconds",
+		Help:      "Unix time the run finished at.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(gateways, gatewaysFinished, gatewayDuration, readyWait, runDuration, runSuccess, runTimestamp)
+}
+
+func SetGateways(count int) {
+	gateways.Set(float64(count))
+}
+
+// GatewayFinished records a gateway which was started, d is the time it took.
+func GatewayFinished(outcome string, d time.Duration) {
+	gatewaysFinished.WithLabelValues(outcome).Inc()
+	gatewayDuration.WithLabelValues(outcome).Observe(d.Seconds())
+}
+
+// GatewayNotStarted records a gateway the run did not get to.
+func GatewayNotStarted(outcome string) {
+	gatewaysFinished.WithLabelValues(outcome).Inc()
+}
+
+func ObserveReadyWait(d time.Duration) {
+	readyWait.Observe(d.Seconds())
+}
+
+func RunFinished(d time.Duration, err error) {
+	runDuration.Set(d.Seconds())
+	runTimestamp.SetToCurrentTime()
+	if err != nil {
+		runSuccess.Set(0)
+	} else {
+		runSuccess.Set(1)
+	}
+}
+
+// Serve exposes the metrics on /metrics of addr until ctx is done.
+func Serve(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		klog.InfoS("Serve metrics", "address", addr, "path", "/metrics")
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			klog.ErrorS(err, "Failed to serve metrics", "address", addr)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+}
+
+// WriteTextfile writes the metrics for the textfile collector of the node exporter, the file is
+// replaced atomically.
+func WriteTextfile(path string) error {
+	return prometheus.WriteToTextfile(path, Registry)
+}
+
+// Push sends the metrics to a Pushgateway, replacing the ones of the previous run.
+func Push(url string) error {
+	return push.New(url, PushJob).Gatherer(Registry).Push()
+}
@@ -1,19 +1,32 @@
 package options
 
+import "time"
+
 type Options struct {
 	KubeConfigPath string
 	GatewayNames   string
 	Backup         *BackupOptions
+	Wait           *WaitOptions
 }
 
 type BackupOptions struct {
 	Enabled bool
 	Dir     string
+	// EncryptionKeyFile holds an age identity, an age public key or a passphrase used to encrypt backups.
+	EncryptionKeyFile string
+}
+
+type WaitOptions struct {
+	// ReadyTimeout bounds the wait for the resources of a gateway release to become ready.
+	ReadyTimeout time.Duration
+	// SettleDelay is waited after a Gateway CR was updated, before its release is checked.
+	SettleDelay time.Duration
 }
 
 func NewOptions() *Options {
 	return &Options{
 		GatewayNames: "",
 		Backup:       &BackupOptions{Enabled: false, Dir: ""},
+		Wait:         &WaitOptions{ReadyTimeout: 5 * time.Minute, SettleDelay: 5 * time.Second},
 	}
 }
@@ -0,0 +1,228 @@
+package render
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+
+	hookAnnotation = "helm.sh/hook"
+)
+
+// kindGroups is the order diffs are reported in, kinds not listed fall into "Other".
+var kindGroups = []struct {
+	Name  string
+	Kinds []string
+}{
+	{Name: "Deployment", Kinds: []string{"Deployment", "DaemonSet"}},
+	{Name: "Service", Kinds: []string{"Service"}},
+	{Name: "ConfigMap", Kinds: []string{"ConfigMap"}},
+	{Name: "RBAC", Kinds: []string{"ServiceAccount", "Role", "RoleBinding", "ClusterRole", "ClusterRoleBinding"}},
+	{Name: "IngressClass", Kinds: []string{"IngressClass"}},
+}
+
+// immutableFields lists per kind the fields the API server refuses to update, changing one of them
+// makes helm fail or forces the object to be recreated, both mean downtime for the gateway.
+var immutableFields = map[string][][]string{
+	"Deployment":         {{"spec", "selector"}},
+	"DaemonSet":          {{"spec", "selector"}},
+	"StatefulSet":        {{"spec", "selector"}, {"spec", "serviceName"}, {"spec", "volumeClaimTemplates"}},
+	"Service":            {{"spec", "clusterIP"}, {"spec", "clusterIPs"}},
+	"IngressClass":       {{"spec", "controller"}},
+	"RoleBinding":        {{"roleRef"}},
+	"ClusterRoleBinding": {{"roleRef"}},
+}
+
+type ObjectDiff struct {
+	Kind      string
+	Namespace string
+	Name      string
+	Change    string
+	// Immutable holds the immutable fields which differ, e.g. spec.selector
+	Immutable []string
+	Diff      string
+}
+
+// DiffManifests compares the objects of two rendered releases. Helm hooks are not part of the
+// release manifest and are skipped.
+func DiffManifests(current, target string) ([]ObjectDiff, error) {
+	currentObjects, err := parseManifest(current)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse current manifest: %w", err)
+	}
+	targetObjects, err := parseManifest(target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse target manifest: %w", err)
+	}
+
+	keys := make([]string, 0, len(currentObjects)+len(targetObjects))
+	for key := range currentObjects {
+		keys = append(keys, key)
+	}
+	for key := range targetObjects {
+		if _, ok := currentObjects[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var diffs []ObjectDiff
+	for _, key := range keys {
+		cur, tgt := currentObjects[key], targetObjects[key]
+		if cur != nil && tgt != nil && reflect.DeepEqual(cur.Object, tgt.Object) {
+			continue
+		}
+		obj := tgt
+		if obj == nil {
+			obj = cur
+		}
+		diff := ObjectDiff{Kind: obj.GetKind(), Namespace: obj.GetNamespace(), Name: obj.GetName()}
+		switch {
+		case cur == nil:
+			diff.Change = ChangeAdded
+		case tgt == nil:
+			diff.Change = ChangeRemoved
+		default:
+			diff.Change = ChangeChanged
+			diff.Immutable = immutableChanges(cur, tgt)
+		}
+		diff.Diff, err = objectDiff(key, cur, tgt)
+		if err != nil {
+			return nil, err
+		}
+		diffs = append(diffs, diff)
+	}
+	return diffs, nil
+}
+
+func parseManifest(manifest string) (map[string]*unstructured.Unstructured, error) {
+	objects := map[string]*unstructured.Unstructured{}
+	for _, doc := range releaseutil.SplitManifests(manifest) {
+		obj := &unstructured.Unstructured{}
+		err := yaml.Unmarshal([]byte(doc), &obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		if len(obj.Object) == 0 || obj.GetAnnotations()[hookAnnotation] != "" {
+			continue
+		}
+		objects[objectKey(obj)] = obj
+	}
+	return objects, nil
+}
+
+func objectKey(obj *unstructured.Unstructured) string {
+	if obj.GetNamespace() == "" {
+		return fmt.Sprintf("%s/%s", obj.GetKind(), obj.GetName())
+	}
+	return fmt.Sprintf("%s/%s/%s", obj.GetKind(), obj.GetNamespace(), obj.GetName())
+}
+
+func immutableChanges(cur, tgt *unstructured.Unstructured) []string {
+	var changed []string
+	for _, field := range immutableFields[cur.GetKind()] {
+		curValue, _, _ := unstructured.NestedFieldNoCopy(cur.Object, field...)
+		tgtValue, _, _ := unstructured.NestedFieldNoCopy(tgt.Object, field...)
+		if !reflect.DeepEqual(curValue, tgtValue) {
+			changed = append(changed, strings.Join(field, "."))
+		}
+	}
+	return changed
+}
+
+func objectDiff(key string, cur, tgt *unstructured.Unstructured) (string, error) {
+	curLines, err := objectLines(cur)
+	if err != nil {
+		return "", err
+	}
+	tgtLines, err := objectLines(tgt)
+	if err != nil {
+		return "", err
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        curLines,
+		B:        tgtLines,
+		FromFile: "current/" + key,
+		ToFile:   "target/" + key,
+		Context:  3,
+	})
+}
+
+func objectLines(obj *unstructured.Unstructured) ([]string, error) {
+	if obj == nil {
+		return nil, nil
+	}
+	out, err := yaml.Marshal(obj.Object)
+	if err != nil {
+		return nil, err
+	}
+	return difflib.SplitLines(strings.TrimSuffix(string(out), "\n")), nil
+}
+
+func kindGroup(kind string) string {
+	for _, group := range kindGroups {
+		for _, k := range group.Kinds {
+			if k == kind {
+				return group.Name
+			}
+		}
+	}
+	return "Other"
+}
+
+// WriteDiffs prints the diffs grouped by kind, immutable field changes are flagged first.
+func WriteDiffs(out io.Writer, diffs []ObjectDiff) error {
+	if len(diffs) == 0 {
+		_, err := fmt.Fprintln(out, "No changes.")
+		return err
+	}
+	groups := map[string][]ObjectDiff{}
+	for _, diff := range diffs {
+		group := kindGroup(diff.Kind)
+		groups[group] = append(groups[group], diff)
+	}
+	order := make([]string, 0, len(kindGroups)+1)
+	for _, group := range kindGroups {
+		order = append(order, group.Name)
+	}
+	order = append(order, "Other")
+
+	for _, group := range order {
+		if len(groups[group]) == 0 {
+			continue
+		}
+		if _, err := fmt.Fprintf(out, "== %s ==\n", group); err != nil {
+			return err
+		}
+		for _, diff := range groups[group] {
+			name := diff.Name
+			if diff.Namespace != "" {
+				name = diff.Namespace + "/" + diff.Name
+			}
+			if _, err := fmt.Fprintf(out, "%s %s (%s)\n", diff.Kind, name, diff.Change); err != nil {
+				return err
+			}
+			for _, field := range diff.Immutable {
+				if _, err := fmt.Fprintf(out, "! immutable field %s changes, the object will be recreated\n", field); err != nil {
+					return err
+				}
+			}
+			if _, err := io.WriteString(out, diff.Diff); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
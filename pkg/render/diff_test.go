@@ -0,0 +1,183 @@
+package render
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testDeployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: gw
+  namespace: ns
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: gw
+`
+	testService = `apiVersion: v1
+kind: Service
+metadata:
+  name: gw
+  namespace: ns
+spec:
+  clusterIP: 10.0.0.1
+  type: NodePort
+`
+	testConfigMap = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: gw
+  namespace: ns
+data:
+  a: b
+`
+	testHook = `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: admission-create
+  namespace: ns
+  annotations:
+    helm.sh/hook: pre-install,pre-upgrade
+spec:
+  backoffLimit: 6
+`
+	testClusterRole = `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: gw
+rules: []
+`
+)
+
+func manifest(docs ...string) string {
+	return "---\n" + strings.Join(docs, "---\n")
+}
+
+func TestDiffManifests(t *testing.T) {
+	tests := []struct {
+		name            string
+		current, target string
+		// want are the diffs without their text
+		want []ObjectDiff
+	}{
+		{
+			name:    "unchanged",
+			current: manifest(testDeployment, testService),
+			target:  manifest(testService, testDeployment),
+		},
+		{
+			name:    "added and removed",
+			current: manifest(testDeployment, testConfigMap),
+			target:  manifest(testDeployment, testClusterRole),
+			want: []ObjectDiff{
+				{Kind: "ClusterRole", Name: "gw", Change: ChangeAdded},
+				{Kind: "ConfigMap", Namespace: "ns", Name: "gw", Change: ChangeRemoved},
+			},
+		},
+		{
+			name:    "changed",
+			current: manifest(testDeployment),
+			target:  manifest(strings.Replace(testDeployment, "replicas: 1", "replicas: 2", 1)),
+			want:    []ObjectDiff{{Kind: "Deployment", Namespace: "ns", Name: "gw", Change: ChangeChanged}},
+		},
+		{
+			name:    "hooks are skipped",
+			current: manifest(testDeployment),
+			target:  manifest(testDeployment, testHook),
+		},
+		{
+			name:    "immutable selector",
+			current: manifest(testDeployment),
+			target:  manifest(strings.Replace(testDeployment, "app: gw", "app.kubernetes.io/name: gw", 1)),
+			want:    []ObjectDiff{{Kind: "Deployment", Namespace: "ns", Name: "gw", Change: ChangeChanged, Immutable: []string{"spec.selector"}}},
+		},
+		{
+			name:    "immutable cluster IP",
+			current: manifest(testService),
+			target:  manifest(strings.Replace(testService, "10.0.0.1", "10.0.0.2", 1)),
+			want:    []ObjectDiff{{Kind: "Service", Namespace: "ns", Name: "gw", Change: ChangeChanged, Immutable: []string{"spec.clusterIP"}}},
+		},
+		{
+			name:    "mutable service change",
+			current: manifest(testService),
+			target:  manifest(strings.Replace(testService, "NodePort", "LoadBalancer", 1)),
+			want:    []ObjectDiff{{Kind: "Service", Namespace: "ns", Name: "gw", Change: ChangeChanged}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diffs, err := DiffManifests(tt.current, tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]ObjectDiff, 0, len(diffs))
+			for _, diff := range diffs {
+				if diff.Diff == "" {
+					t.Errorf("%s %s has no diff", diff.Kind, diff.Name)
+				}
+				diff.Diff = ""
+				got = append(got, diff)
+			}
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffManifests() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffManifestsInvalid(t *testing.T) {
+	if _, err := DiffManifests(manifest(testDeployment), "---\nkind: [\n"); err == nil {
+		t.Errorf("DiffManifests() of an invalid target manifest succeeded")
+	}
+}
+
+func TestWriteDiffs(t *testing.T) {
+	tests := []struct {
+		name  string
+		diffs []ObjectDiff
+		want  string
+	}{
+		{name: "no changes", want: "No changes.\n"},
+		{
+			name: "grouped by kind",
+			diffs: []ObjectDiff{
+				{Kind: "Job", Namespace: "ns", Name: "job", Change: ChangeAdded, Diff: "job diff\n"},
+				{Kind: "ClusterRole", Name: "gw", Change: ChangeAdded, Diff: "role diff\n"},
+				{Kind: "Service", Namespace: "ns", Name: "gw", Change: ChangeChanged, Immutable: []string{"spec.clusterIP"}, Diff: "service diff\n"},
+				{Kind: "DaemonSet", Namespace: "ns", Name: "gw", Change: ChangeRemoved, Diff: "daemonset diff\n"},
+			},
+			want: "== Deployment ==\n" +
+				"DaemonSet ns/gw (removed)\n" +
+				"daemonset diff\n" +
+				"== Service ==\n" +
+				"Service ns/gw (changed)\n" +
+				"! immutable field spec.clusterIP changes, the object will be recreated\n" +
+				"service diff\n" +
+				"== RBAC ==\n" +
+				"ClusterRole gw (added)\n" +
+				"role diff\n" +
+				"== Other ==\n" +
+				"Job ns/job (added)\n" +
+				"job diff\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := WriteDiffs(&out, tt.diffs); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("WriteDiffs() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
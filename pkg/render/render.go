@@ -0,0 +1,173 @@
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/render/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/backup"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/kubeclient"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/simple/helmwrapper"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/template"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
+)
+
+// Renderer renders the manifests of the target release of gateways. Rendering gateways from a
+// backup with a local gateway config does not need a cluster, Client is nil then.
+type Renderer struct {
+	Client        client.Client
+	GatewayNames  []*gatewayv2alpha2.GatewayReference
+	Kubeconfig    []byte
+	RenderOptions options.RenderOptions
+}
+
+func NewRenderer(options *options.RenderOptions) (*Renderer, error) {
+	r := &Renderer{}
+	r.RenderOptions = *options
+	if options.FromBackup == "" || options.GatewayConfigFile == "" || options.Diff {
+		kubeClient, err := kubeclient.New(options.KubeConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		r.Client = kubeClient
+	}
+	if options.KubeConfigPath != "" {
+		file, err := os.ReadFile(options.KubeConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		r.Kubeconfig = file
+	}
+	if options.GatewayNames != "" && !upgrade.GetAll(options.GatewayNames) {
+		r.GatewayNames = upgrade.NewGatewayReferences(options.GatewayNames)
+	}
+	return r, nil
+}
+
+func (r *Renderer) Run(ctx context.Context, out io.Writer) error {
+	chartData, err := LoadChart(r.RenderOptions.ChartPath)
+	if err != nil {
+		return err
+	}
+	gatewayConfig, err := r.gatewayConfig(ctx)
+	if err != nil {
+		return err
+	}
+	gateways, err := r.getGateways(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, gw := range gateways {
+		var service *corev1.Service
+		if r.RenderOptions.FromBackup == "" {
+			service = &corev1.Service{}
+			err := r.Client.Get(ctx, types.NamespacedName{Namespace: gw.Namespace, Name: gw.Name}, service)
+			if err != nil {
+				return err
+			}
+		}
+		manifest, err := Render(&gw, service, chartData, gatewayConfig)
+		if err != nil {
+			return fmt.Errorf("failed to render gateway %s/%s: %w", gw.Namespace, gw.Name, err)
+		}
+		_, err = fmt.Fprintf(out, "# Gateway: %s/%s\n", gw.Namespace, gw.Name)
+		if err != nil {
+			return err
+		}
+		if !r.RenderOptions.Diff {
+			_, err = io.WriteString(out, manifest)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		current, err := helmwrapper.NewHelmWrapper(string(r.Kubeconfig), gw.Namespace, gw.Name).Manifest()
+		if err != nil {
+			return fmt.Errorf("failed to get current manifest of gateway %s/%s: %w", gw.Namespace, gw.Name, err)
+		}
+		diffs, err := DiffManifests(current, manifest)
+		if err != nil {
+			return err
+		}
+		err = WriteDiffs(out, diffs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Render returns the manifests the target release of gw would produce, see upgrade.TargetValues
+// for service and gatewayConfig.
+func Render(gw *gatewayv2alpha2.Gateway, service *corev1.Service, chartData []byte, gatewayConfig string) (string, error) {
+	values, err := upgrade.TargetValues(gw, service, gatewayConfig)
+	if err != nil {
+		return "", err
+	}
+	wrapper := helmwrapper.NewHelmWrapper("", gw.Namespace, gw.Name)
+	return wrapper.Render(chartData, values)
+}
+
+// LoadChart reads the chart tgz at path, or the embedded chart if path is empty.
+func LoadChart(path string) ([]byte, error) {
+	if path == "" {
+		return template.EmbeddedChart()
+	}
+	return os.ReadFile(path)
+}
+
+func (r *Renderer) gatewayConfig(ctx context.Context) (string, error) {
+	if r.RenderOptions.GatewayConfigFile != "" {
+		config, err := os.ReadFile(r.RenderOptions.GatewayConfigFile)
+		if err != nil {
+			return "", err
+		}
+		return string(config), nil
+	}
+	return upgrade.GetGatewayConfig(ctx, r.Client)
+}
+
+func (r *Renderer) getGateways(ctx context.Context) ([]gatewayv2alpha2.Gateway, error) {
+	if r.RenderOptions.FromBackup != "" {
+		gateways, err := backup.Read(r.RenderOptions.FromBackup, r.RenderOptions.Backup.EncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if upgrade.GetAll(r.RenderOptions.GatewayNames) {
+			return gateways, nil
+		}
+		return backup.Select(gateways, r.GatewayNames)
+	}
+
+	if upgrade.GetAll(r.RenderOptions.GatewayNames) {
+		gatewayList := &gatewayv2alpha2.GatewayList{}
+		err := r.Client.List(ctx, gatewayList)
+		if err != nil {
+			return nil, err
+		}
+		return gatewayList.Items, nil
+	}
+	if len(r.GatewayNames) == 0 {
+		return nil, errors.New("no gateway selected, use --gateways to choose the gateways to render")
+	}
+	var list []gatewayv2alpha2.Gateway
+	for _, fullName := range r.GatewayNames {
+		gateway := &gatewayv2alpha2.Gateway{}
+		err := r.Client.Get(ctx, fullName.ToNamespacedName(), gateway)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *gateway)
+	}
+	return list, nil
+}
@@ -0,0 +1,34 @@
+package render
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/template"
+)
+
+func TestLoadChart(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "chart.tgz")
+	if err := os.WriteFile(local, []byte("local chart"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := LoadChart(local)
+	if err != nil || string(data) != "local chart" {
+		t.Errorf("LoadChart(%q) = %q, %v, want the local chart", local, data, err)
+	}
+
+	// Without --chart the chart embedded at build time is used, if there is one.
+	embedded, embeddedErr := template.EmbeddedChart()
+	data, err = LoadChart("")
+	switch {
+	case embeddedErr != nil:
+		if err == nil || !strings.Contains(err.Error(), "--chart") {
+			t.Errorf("LoadChart(\"\") error = %v, want a hint to pass --chart", err)
+		}
+	case err != nil || !bytes.Equal(data, embedded):
+		t.Errorf("LoadChart(\"\") = %d bytes, %v, want the embedded chart", len(data), err)
+	}
+}
@@ -0,0 +1,181 @@
+package rollback
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/simple/helmwrapper"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
+)
+
+// RollbackWithHelmHistory rolls every selected gateway release back to the last revision deployed
+// with the previous chart version, then realigns the Gateway CR with that revision so the gateway
+// controller does not upgrade the release again.
+func (r *Runner) RollbackWithHelmHistory(ctx context.Context) error {
+	gateways, err := r.getGateways(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get gateways: %w", err)
+	}
+	for _, gw := range gateways {
+		klog.InfoS("Begin to rollback gateway through helm history", "namespace", gw.Namespace, "gateway", gw.Name)
+		err := r.rollbackRelease(ctx, gw)
+		if err != nil {
+			return fmt.Errorf("failed to rollback gateway %s/%s: %w", gw.Namespace, gw.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) getGateways(ctx context.Context) ([]gatewayv2alpha2.Gateway, error) {
+	if upgrade.GetAll(r.RollbackOptions.GatewayNames) {
+		gatewayList := &gatewayv2alpha2.GatewayList{}
+		err := r.Client.List(ctx, gatewayList)
+		if err != nil {
+			return nil, err
+		}
+		return gatewayList.Items, nil
+	}
+	if len(r.GatewayNames) == 0 {
+		return nil, errors.New("no gateway selected, use --gateways to choose the gateways to rollback")
+	}
+	var list []gatewayv2alpha2.Gateway
+	for _, fullName := range r.GatewayNames {
+		gateway := &gatewayv2alpha2.Gateway{}
+		err := r.Client.Get(ctx, fullName.ToNamespacedName(), gateway)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, *gateway)
+	}
+	return list, nil
+}
+
+func (r *Runner) rollbackRelease(ctx context.Context, gw gatewayv2alpha2.Gateway) error {
+	wrapper := helmwrapper.NewHelmWrapper(string(r.Kubeconfig), gw.Namespace, gw.Name)
+	history, err := wrapper.History()
+	if err != nil {
+		return err
+	}
+	target, err := previousChartRevision(history)
+	if err != nil {
+		return err
+	}
+	values, err := json.Marshal(target.Config)
+	if err != nil {
+		return err
+	}
+	aligned := gw.DeepCopy()
+	aligned.Spec.AppVersion = upgrade.AppVersionOf(target)
+	aligned.Spec.Values = runtime.RawExtension{Raw: values}
+
+	diff, err := specDiff(&gw, aligned, fmt.Sprintf("revision-%d", target.Version))
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Gateway will be rolled back", "namespace", gw.Namespace, "gateway", gw.Name, "revision", target.Version, "appVersion", aligned.Spec.AppVersion)
+	fmt.Print(diff)
+	if r.RollbackOptions.DryRun {
+		return nil
+	}
+
+	start := time.Now()
+	err = r.applyRollback(ctx, gw, aligned, target, history[len(history)-1].Version)
+	r.recordHistory(ctx, &gw, aligned, "", start, err)
+	return err
+}
+
+// applyRollback rolls the release back to target and realigns the CR with it, current is the
+// revision the history ended with.
+func (r *Runner) applyRollback(ctx context.Context, gw gatewayv2alpha2.Gateway, aligned *gatewayv2alpha2.Gateway, target *helmrelease.Release, current int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Once the IngressClass is gone the release and the CR have to follow, a signal must not split them.
+	mutateCtx := context.WithoutCancel(ctx)
+	ingressClassName, err := upgrade.DeleteIngressClass(mutateCtx, r.Client, gw.Name)
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Deleted ingress class", "namespace", gw.Namespace, "gateway", gw.Name, "ingressClass", ingressClassName)
+
+	wrapper := helmwrapper.NewHelmWrapper(string(r.Kubeconfig), gw.Namespace, gw.Name)
+	err = wrapper.Rollback(target.Version, false, r.RollbackOptions.Wait.ReadyTimeout)
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Rolled back release", "namespace", gw.Namespace, "gateway", gw.Name, "revision", target.Version)
+
+	// The gateway controller keeps updating the status, so refetch the CR on conflicts.
+	var reconcile *upgrade.Reconcile
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest := &gatewayv2alpha2.Gateway{}
+		err := r.Client.Get(mutateCtx, types.NamespacedName{Namespace: gw.Namespace, Name: gw.Name}, latest)
+		if err != nil {
+			return err
+		}
+		latest.Spec.AppVersion = aligned.Spec.AppVersion
+		latest.Spec.Values = aligned.Spec.Values
+		generation := latest.Generation
+		err = r.Client.Update(mutateCtx, latest)
+		if err != nil {
+			return err
+		}
+		// The rollback itself already replaced the release the history ended with.
+		reconcile = upgrade.ReconcileOf(latest, generation, current)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to realign gateway CR with revision %d: %w", target.Version, err)
+	}
+	err = upgrade.WaitForRelease(ctx, r.Client, r.Kubeconfig, gw.Namespace, gw.Name, reconcile, r.RollbackOptions.Wait)
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Gateway release is ready", "namespace", gw.Namespace, "gateway", gw.Name)
+	return nil
+}
+
+// previousChartRevision returns the newest revision deployed with a chart version other than the
+// one of the current release. history must be ordered by revision.
+func previousChartRevision(history []*helmrelease.Release) (*helmrelease.Release, error) {
+	var current *helmrelease.Release
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Info != nil && history[i].Info.Status == helmrelease.StatusDeployed {
+			current = history[i]
+			break
+		}
+	}
+	if current == nil {
+		return nil, errors.New("release has no deployed revision")
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		rel := history[i]
+		if rel.Version >= current.Version || rel.Info == nil {
+			continue
+		}
+		if rel.Info.Status != helmrelease.StatusSuperseded && rel.Info.Status != helmrelease.StatusDeployed {
+			continue
+		}
+		if chartVersion(rel) != chartVersion(current) {
+			return rel, nil
+		}
+	}
+	return nil, fmt.Errorf("no revision deployed with a chart version other than %s is left in the release history", chartVersion(current))
+}
+
+func chartVersion(rel *helmrelease.Release) string {
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return ""
+	}
+	return rel.Chart.Metadata.Version
+}
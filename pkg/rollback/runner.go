@@ -0,0 +1,182 @@
+package rollback
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pmezard/go-difflib/difflib"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/rollback/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/backup"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/history"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/kubeclient"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/lock"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
+)
+
+type Runner struct {
+	Client          client.Client
+	GatewayNames    []*gatewayv2alpha2.GatewayReference
+	Kubeconfig      []byte
+	RollbackOptions options.RollbackOptions
+}
+
+func NewRunner(options *options.RollbackOptions) (*Runner, error) {
+	r := &Runner{}
+	kubeClient, err := kubeclient.New(options.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	r.Client = kubeClient
+	r.RollbackOptions = *options
+	if r.RollbackOptions.KubeConfigPath != "" {
+		file, err := os.ReadFile(options.KubeConfigPath)
+		if err != nil {
+			return nil, err
+		}
+		r.Kubeconfig = file
+	}
+	if options.GatewayNames != "" && !upgrade.GetAll(options.GatewayNames) {
+		r.GatewayNames = upgrade.NewGatewayReferences(options.GatewayNames)
+	}
+	return r, nil
+}
+
+// Run rolls back the gateways while holding the run lock of the upgrade, so a rollback and an
+// upgrade never change gateways at the same time. A dry run changes nothing and runs unlocked.
+func (r *Runner) Run(ctx context.Context) error {
+	if r.RollbackOptions.UseHelmHistory && r.RollbackOptions.FromBackup != "" {
+		return errors.New("--from-backup and --use-helm-history are mutually exclusive")
+	}
+	if !r.RollbackOptions.UseHelmHistory && r.RollbackOptions.FromBackup == "" {
+		return errors.New("one of --from-backup or --use-helm-history is required")
+	}
+	if !r.RollbackOptions.DryRun {
+		lease := lock.New(r.Client, upgrade.ExtensionNamespace)
+		var err error
+		ctx, err = lease.Acquire(ctx, r.RollbackOptions.ForceUnlock)
+		if err != nil {
+			return err
+		}
+		defer lease.Release()
+	}
+	if r.RollbackOptions.UseHelmHistory {
+		return r.RollbackWithHelmHistory(ctx)
+	}
+	return r.RestoreFromBackup(ctx)
+}
+
+// RestoreFromBackup restores the selected gateways from the backup file and leaves the
+// other gateways in it untouched.
+func (r *Runner) RestoreFromBackup(ctx context.Context) error {
+	backupPath := r.RollbackOptions.FromBackup
+	backedUp, err := backup.Read(backupPath, r.RollbackOptions.Backup.EncryptionKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read backup %s: %w", backupPath, err)
+	}
+	gateways, err := r.selectGateways(backedUp)
+	if err != nil {
+		return err
+	}
+	for _, gw := range gateways {
+		klog.InfoS("Begin to restore gateway from the backup", "namespace", gw.Namespace, "gateway", gw.Name, "backup", backupPath)
+		err := r.restore(ctx, gw)
+		if err != nil {
+			return fmt.Errorf("failed to restore gateway %s/%s: %w", gw.Namespace, gw.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) selectGateways(backedUp []gatewayv2alpha2.Gateway) ([]gatewayv2alpha2.Gateway, error) {
+	if upgrade.GetAll(r.RollbackOptions.GatewayNames) {
+		return backedUp, nil
+	}
+	if len(r.GatewayNames) == 0 {
+		return nil, errors.New("no gateway selected, use --gateways to choose the gateways to restore")
+	}
+	selected, err := backup.Select(backedUp, r.GatewayNames)
+	if err != nil {
+		return nil, fmt.Errorf("%w %s", err, r.RollbackOptions.FromBackup)
+	}
+	return selected, nil
+}
+
+func (r *Runner) restore(ctx context.Context, backedUp gatewayv2alpha2.Gateway) error {
+	live := &gatewayv2alpha2.Gateway{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: backedUp.Namespace, Name: backedUp.Name}, live)
+	if err != nil {
+		return err
+	}
+
+	diff, err := specDiff(live, &backedUp, "backup")
+	if err != nil {
+		return err
+	}
+	if diff == "" {
+		klog.InfoS("Gateway already matches the backup, skip it", "namespace", live.Namespace, "gateway", live.Name)
+		return nil
+	}
+	fmt.Print(diff)
+	if r.RollbackOptions.DryRun {
+		return nil
+	}
+
+	start := time.Now()
+	err = upgrade.RestoreSpec(ctx, r.Client, r.Kubeconfig, live, backedUp.Spec, r.RollbackOptions.Wait)
+	r.recordHistory(ctx, live, &backedUp, r.RollbackOptions.FromBackup, start, err)
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Restored gateway", "namespace", live.Namespace, "gateway", live.Name, "appVersion", backedUp.Spec.AppVersion)
+	return nil
+}
+
+// specDiff returns the unified diff from the live gateway spec to the target one, labelled
+// with source. It is empty if both are equal.
+func specDiff(live, target *gatewayv2alpha2.Gateway, source string) (string, error) {
+	liveSpec, err := yaml.Marshal(live.Spec)
+	if err != nil {
+		return "", err
+	}
+	targetSpec, err := yaml.Marshal(target.Spec)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s/%s", live.Namespace, live.Name)
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(liveSpec)),
+		B:        difflib.SplitLines(string(targetSpec)),
+		FromFile: "live/" + name,
+		ToFile:   source + "/" + name,
+		Context:  3,
+	})
+}
+
+// recordHistory appends the rollback of live to target to the history ledger, err is the outcome.
+// A ledger which cannot be written does not fail the rollback.
+func (r *Runner) recordHistory(ctx context.Context, live, target *gatewayv2alpha2.Gateway, backupPath string, start time.Time, err error) {
+	ctx = context.WithoutCancel(ctx)
+	entry := history.NewEntry(history.OperationRollback, fmt.Sprintf("%s/%s", live.Namespace, live.Name), start, time.Since(start))
+	entry.User = history.User(ctx, r.Client)
+	entry.FromVersion = live.Spec.AppVersion
+	entry.ToVersion = target.Spec.AppVersion
+	entry.ValuesHash = history.ValuesHash(target.Spec.Values.Raw)
+	entry.Backup = backupPath
+	entry.Outcome = history.OutcomeSucceeded
+	if err != nil {
+		entry.Outcome, entry.Reason = history.OutcomeFailed, err.Error()
+	}
+	err = history.New(r.Client, upgrade.ExtensionNamespace).Append(ctx, entry)
+	if err != nil {
+		klog.ErrorS(err, "Failed to record the rollback history", "namespace", live.Namespace, "gateway", live.Name)
+	}
+}
@@ -0,0 +1,48 @@
+package rollback
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/rollback/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/lock"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/upgrade"
+)
+
+func TestRunHoldsRunLock(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	// An upgrade is running.
+	held := lock.New(c, upgrade.ExtensionNamespace)
+	if _, err := held.Acquire(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release()
+
+	tests := []struct {
+		name    string
+		dryRun  bool
+		wantErr string
+	}{
+		{name: "rollback", wantErr: "is held by"},
+		// The dry run gets past the lock and fails on the missing backup.
+		{name: "dry run", dryRun: true, wantErr: "failed to read backup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rollbackOptions := options.NewRollbackOptions()
+			rollbackOptions.FromBackup = "/nonexistent/backup.yaml"
+			rollbackOptions.DryRun = tt.dryRun
+			r := &Runner{Client: c, RollbackOptions: *rollbackOptions}
+
+			err := r.Run(ctx)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Run() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
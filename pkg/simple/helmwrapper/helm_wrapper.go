@@ -18,17 +18,23 @@ package helmwrapper
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/pmezard/go-difflib/difflib"
 	"helm.sh/helm/v3/pkg/action"
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
 
 	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/kube"
 	helmrelease "helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	kpath "k8s.io/utils/path"
 
@@ -62,8 +68,23 @@ type HelmWrapper interface {
 	Uninstall() error
 	// Get manifests
 	Manifest() (string, error)
-	// IsReleaseReady check helm release is ready or not
-	IsReleaseReady(timeout time.Duration) (bool, error)
+	// Status returns the current release
+	Status() (*helmrelease.Release, error)
+	// IsReleaseReady check helm release is ready or not, giving up after timeout or when ctx is done
+	IsReleaseReady(ctx context.Context, timeout time.Duration) (bool, error)
+	// History returns all stored revisions of the release, ordered by revision
+	History() ([]*helmrelease.Release, error)
+	// GetValues returns the user supplied values of the release, merged with the chart defaults if all is true
+	GetValues(all bool) (map[string]interface{}, error)
+	// Rollback the release to the given revision, waiting up to timeout for its hooks, and for its
+	// resources to be ready if wait is true
+	Rollback(revision int, wait bool, timeout time.Duration) error
+	// Diff renders an upgrade to the chart and values without applying it and returns the unified diff
+	// from the current manifest, empty if nothing would change
+	Diff(chartData, values []byte) (string, error)
+	// Render returns the manifests, hooks included, a fresh install of the chart would create,
+	// without contacting the cluster
+	Render(chartData, values []byte) (string, error)
 }
 
 func NewHelmWrapper(kubeconfig, ns, rls string, options ...Option) *helmWrapper {
@@ -75,10 +96,10 @@ func NewHelmWrapper(kubeconfig, ns, rls string, options ...Option) *helmWrapper
 		workspaceSuffix: "kubesphere",
 	}
 
-	klog.V(8).Infof("namespace: %s, name: %s, release: %s, kubeconfig:%s", c.Namespace, c.ReleaseName, rls, kubeconfig)
+	klog.V(8).InfoS("Create helm wrapper", "namespace", c.Namespace, "gateway", c.ReleaseName, "kubeconfig", kubeconfig)
 	getter := NewClusterRESTClientGetter(kubeconfig, ns)
 	c.helmConf = new(action.Configuration)
-	c.helmConf.Init(getter, ns, "", klog.Infof)
+	c.helmConf.Init(getter, ns, "", c.logf)
 
 	for _, option := range options {
 		option(c)
@@ -89,17 +110,40 @@ func NewHelmWrapper(kubeconfig, ns, rls string, options ...Option) *helmWrapper
 
 // IsReleaseReady check helm releases is ready or not
 // If the return values is (true, nil), then the resources are ready
-func (c *helmWrapper) IsReleaseReady(waitTime time.Duration) (bool, error) {
+// Unlike helm's own Wait it stops polling as soon as ctx is done.
+func (c *helmWrapper) IsReleaseReady(ctx context.Context, waitTime time.Duration) (bool, error) {
 	// Get the manifest to build resources
 	manifest, err := c.Manifest()
 	if err != nil {
 		return false, err
 	}
 
-	client := c.helmConf.KubeClient
+	client, ok := c.helmConf.KubeClient.(*kube.Client)
+	if !ok {
+		return false, fmt.Errorf("unsupported helm kube client %T", c.helmConf.KubeClient)
+	}
 	resources, _ := client.Build(bytes.NewBufferString(manifest), true)
-
-	if err := client.Wait(resources, waitTime); err != nil {
+	clientSet, err := client.Factory.KubernetesClientSet()
+	if err != nil {
+		return false, err
+	}
+	checker := kube.NewReadyChecker(clientSet, c.logf, kube.PausedAsReady(true))
+
+	err = wait.PollUntilContextTimeout(ctx, 2*time.Second, waitTime, true, func(ctx context.Context) (bool, error) {
+		for _, resource := range resources {
+			ready, err := checker.IsReady(ctx, resource)
+			if err != nil {
+				// Resources may not be created yet right after the release, keep polling.
+				klog.V(4).InfoS("Check resource failed", "namespace", c.Namespace, "gateway", c.ReleaseName, "resource", resource.Name, "err", err)
+				return false, nil
+			}
+			if !ready {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
 		return false, err
 	}
 	return true, nil
@@ -110,18 +154,23 @@ func (c *helmWrapper) Status() (*helmrelease.Release, error) {
 	rel, err := helmStatus.Run(c.ReleaseName)
 	if err != nil {
 		if err.Error() == StatusNotFoundFormat {
-			klog.V(2).Infof("namespace: %s, name: %s, run command failed, error: %v", c.Namespace, c.ReleaseName, err)
+			klog.V(2).InfoS("Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName, "err", err)
 			return nil, err
 		}
-		klog.Errorf("namespace: %s, name: %s, run command failed, error: %v", c.Namespace, c.ReleaseName, err)
+		klog.ErrorS(err, "Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName)
 		return nil, err
 	}
 
-	klog.V(2).Infof("namespace: %s, name: %s, run command success", c.Namespace, c.ReleaseName)
-	klog.V(8).Infof("namespace: %s, name: %s, run command success, manifest: %s", c.Namespace, c.ReleaseName, rel.Manifest)
+	klog.V(2).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName)
+	klog.V(8).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName, "manifest", rel.Manifest)
 	return rel, nil
 }
 
+// logf keeps the log lines of helm actions attributable when several releases are handled at once.
+func (c *helmWrapper) logf(format string, v ...interface{}) {
+	klog.InfoSDepth(1, fmt.Sprintf(format, v...), "namespace", c.Namespace, "gateway", c.ReleaseName, "component", "helm")
+}
+
 func (c *helmWrapper) Workspace() string {
 	if c.workspaceSuffix == "" {
 		return filepath.Join(c.base, fmt.Sprintf("%s_%s", c.Namespace, c.ReleaseName))
@@ -157,7 +206,7 @@ func (c *helmWrapper) chartPath() string {
 
 func (c *helmWrapper) cleanup() {
 	if err := os.RemoveAll(c.Workspace()); err != nil {
-		klog.Errorf("remove dir %s failed, error: %s", c.Workspace(), err)
+		klog.ErrorS(err, "Remove dir failed", "namespace", c.Namespace, "gateway", c.ReleaseName, "dir", c.Workspace())
 	}
 }
 
@@ -193,19 +242,19 @@ func SetLabels(labels map[string]string) Option {
 // If not exists, create workspace dir.
 func (c *helmWrapper) ensureWorkspace() error {
 	if exists, err := kpath.Exists(kpath.CheckFollowSymlink, c.Workspace()); err != nil {
-		klog.Errorf("check dir %s failed, error: %s", c.Workspace(), err)
+		klog.ErrorS(err, "Check dir failed", "namespace", c.Namespace, "gateway", c.ReleaseName, "dir", c.Workspace())
 		return err
 	} else if !exists {
 		err = os.MkdirAll(c.Workspace(), os.ModeDir|os.ModePerm)
 		if err != nil {
-			klog.Errorf("mkdir %s failed, error: %s", c.Workspace(), err)
+			klog.ErrorS(err, "Mkdir failed", "namespace", c.Namespace, "gateway", c.ReleaseName, "dir", c.Workspace())
 			return err
 		}
 	}
 
 	err := os.MkdirAll(c.chartDir(), os.ModeDir|os.ModePerm)
 	if err != nil {
-		klog.Errorf("mkdir %s failed, error: %s", c.chartDir(), err)
+		klog.ErrorS(err, "Mkdir failed", "namespace", c.Namespace, "gateway", c.ReleaseName, "dir", c.chartDir())
 		return err
 	}
 
@@ -250,7 +299,7 @@ func (c *helmWrapper) createChart(chartData, values []byte, chartName string) er
 func (c *helmWrapper) Uninstall() error {
 	start := time.Now()
 	defer func() {
-		klog.V(2).Infof("run command end, namespace: %s, name: %s elapsed: %v", c.Namespace, c.ReleaseName, time.Since(start))
+		klog.V(2).InfoS("Run command end", "namespace", c.Namespace, "gateway", c.ReleaseName, "elapsed", time.Since(start))
 	}()
 
 	uninstall := action.NewUninstall(c.helmConf)
@@ -264,10 +313,10 @@ func (c *helmWrapper) Uninstall() error {
 		if fmt.Sprintf(UninstallNotFoundFormat, c.ReleaseName) == err.Error() {
 			return nil
 		}
-		klog.Errorf("run command failed, error: %v", err)
+		klog.ErrorS(err, "Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName)
 		return err
 	} else {
-		klog.V(2).Infof("namespace: %s, name: %s, run command success", c.Namespace, c.ReleaseName)
+		klog.V(2).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName)
 	}
 
 	return nil
@@ -321,7 +370,7 @@ func (c *helmWrapper) InstallOrUpgrade(chartData, values []byte, chartName strin
 	return nil
 }
 
-func (c *helmWrapper) helmUpgrade(chart *chart.Chart, values map[string]interface{}) (*helmrelease.Release, error) {
+func (c *helmWrapper) newUpgrade() *action.Upgrade {
 	upgrade := action.NewUpgrade(c.helmConf)
 	upgrade.Namespace = c.Namespace
 	upgrade.MaxHistory = 3
@@ -333,11 +382,14 @@ func (c *helmWrapper) helmUpgrade(chart *chart.Chart, values map[string]interfac
 		postRenderer := newPostRendererKustomize(c.labels, c.annotations)
 		upgrade.PostRenderer = postRenderer
 	}
+	return upgrade
+}
 
-	return upgrade.Run(c.ReleaseName, chart, values)
+func (c *helmWrapper) helmUpgrade(chart *chart.Chart, values map[string]interface{}) (*helmrelease.Release, error) {
+	return c.newUpgrade().Run(c.ReleaseName, chart, values)
 }
 
-func (c *helmWrapper) helmInstall(chart *chart.Chart, values map[string]interface{}) (*helmrelease.Release, error) {
+func (c *helmWrapper) newInstall() *action.Install {
 	install := action.NewInstall(c.helmConf)
 	install.ReleaseName = c.ReleaseName
 	install.Namespace = c.Namespace
@@ -349,15 +401,18 @@ func (c *helmWrapper) helmInstall(chart *chart.Chart, values map[string]interfac
 		postRenderer := newPostRendererKustomize(c.labels, c.annotations)
 		install.PostRenderer = postRenderer
 	}
+	return install
+}
 
-	return install.Run(chart, values)
+func (c *helmWrapper) helmInstall(chart *chart.Chart, values map[string]interface{}) (*helmrelease.Release, error) {
+	return c.newInstall().Run(chart, values)
 }
 
 func (c *helmWrapper) writeAction(chartData, values []byte, chartName string, upgrade bool) error {
 	if klog.V(2).Enabled() {
 		start := time.Now()
 		defer func() {
-			klog.V(2).Infof("run command end, namespace: %s, name: %s, upgrade: %t, elapsed: %v", c.Namespace, c.ReleaseName, upgrade, time.Since(start))
+			klog.V(2).InfoS("Run command end", "namespace", c.Namespace, "gateway", c.ReleaseName, "upgrade", upgrade, "elapsed", time.Since(start))
 		}()
 	}
 
@@ -369,7 +424,7 @@ func (c *helmWrapper) writeAction(chartData, values []byte, chartName string, up
 	if err := c.createChart(chartData, values, chartName); err != nil {
 		return err
 	}
-	klog.V(8).Infof("namespace: %s, name: %s, chart values: %s", c.Namespace, c.ReleaseName, values)
+	klog.V(8).InfoS("Chart values", "namespace", c.Namespace, "gateway", c.ReleaseName, "values", string(values))
 
 	chartRequested, err := loader.Load(c.chartPath())
 	if err != nil {
@@ -389,12 +444,12 @@ func (c *helmWrapper) writeAction(chartData, values []byte, chartName string, up
 	}
 
 	if err != nil {
-		klog.Errorf("namespace: %s, name: %s,  error: %v", c.Namespace, c.ReleaseName, err)
+		klog.ErrorS(err, "Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName)
 		return err
 	}
 
-	klog.V(2).Infof("namespace: %s, name: %s, run command success", c.Namespace, c.ReleaseName)
-	klog.V(8).Infof("namespace: %s, name: %s, run command success, manifest: %s", c.Namespace, c.ReleaseName, rel.Manifest)
+	klog.V(2).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName)
+	klog.V(8).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName, "manifest", rel.Manifest)
 	return nil
 }
 
@@ -404,10 +459,128 @@ func (c *helmWrapper) Manifest() (string, error) {
 	rel, err := get.Run(c.ReleaseName)
 
 	if err != nil {
-		klog.Errorf("namespace: %s, name: %s, run command failed, error: %v", c.Namespace, c.ReleaseName, err)
+		klog.ErrorS(err, "Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName)
 		return "", err
 	}
-	klog.V(2).Infof("namespace: %s, name: %s, run command success", c.Namespace, c.ReleaseName)
-	klog.V(8).Infof("namespace: %s, name: %s, run command success, manifest: %s", c.Namespace, c.ReleaseName, rel.Manifest)
+	klog.V(2).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName)
+	klog.V(8).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName, "manifest", rel.Manifest)
 	return rel.Manifest, nil
 }
+
+// helm history
+func (c *helmWrapper) History() ([]*helmrelease.Release, error) {
+	history := action.NewHistory(c.helmConf)
+
+	rels, err := history.Run(c.ReleaseName)
+	if err != nil {
+		klog.ErrorS(err, "Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName)
+		return nil, err
+	}
+	releaseutil.SortByRevision(rels)
+	klog.V(2).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName, "revisions", len(rels))
+	return rels, nil
+}
+
+// helm rollback
+func (c *helmWrapper) Rollback(revision int, wait bool, timeout time.Duration) error {
+	start := time.Now()
+	defer func() {
+		klog.V(2).InfoS("Run command end", "namespace", c.Namespace, "gateway", c.ReleaseName, "revision", revision, "elapsed", time.Since(start))
+	}()
+
+	rollback := action.NewRollback(c.helmConf)
+	rollback.Version = revision
+	rollback.MaxHistory = 3
+	rollback.Wait = wait
+	rollback.Timeout = timeout
+
+	if c.dryRun {
+		rollback.DryRun = true
+	}
+
+	err := rollback.Run(c.ReleaseName)
+	if err != nil {
+		klog.ErrorS(err, "Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName, "revision", revision)
+		return err
+	}
+	klog.V(2).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName, "revision", revision)
+	return nil
+}
+
+// helm get values
+func (c *helmWrapper) GetValues(all bool) (map[string]interface{}, error) {
+	getValues := action.NewGetValues(c.helmConf)
+	getValues.AllValues = all
+
+	values, err := getValues.Run(c.ReleaseName)
+	if err != nil {
+		klog.ErrorS(err, "Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName)
+		return nil, err
+	}
+	klog.V(2).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName)
+	return values, nil
+}
+
+// helm upgrade --dry-run, compared with the current manifest
+func (c *helmWrapper) Diff(chartData, values []byte) (string, error) {
+	chartRequested, err := loader.LoadArchive(bytes.NewReader(chartData))
+	if err != nil {
+		return "", err
+	}
+	helmValues, err := chartutil.ReadValues(values)
+	if err != nil {
+		return "", err
+	}
+	current, err := c.Manifest()
+	if err != nil {
+		return "", err
+	}
+
+	upgrade := c.newUpgrade()
+	upgrade.DryRun = true
+	rel, err := upgrade.Run(c.ReleaseName, chartRequested, helmValues.AsMap())
+	if err != nil {
+		klog.ErrorS(err, "Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName)
+		return "", err
+	}
+	klog.V(8).InfoS("Run command success", "namespace", c.Namespace, "gateway", c.ReleaseName, "manifest", rel.Manifest)
+
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(current),
+		B:        difflib.SplitLines(rel.Manifest),
+		FromFile: fmt.Sprintf("%s/%s (current)", c.Namespace, c.ReleaseName),
+		ToFile:   fmt.Sprintf("%s/%s (target)", c.Namespace, c.ReleaseName),
+		Context:  3,
+	})
+}
+
+// helm install --dry-run=client, like helm template
+func (c *helmWrapper) Render(chartData, values []byte) (string, error) {
+	chartRequested, err := loader.LoadArchive(bytes.NewReader(chartData))
+	if err != nil {
+		return "", err
+	}
+	helmValues, err := chartutil.ReadValues(values)
+	if err != nil {
+		return "", err
+	}
+
+	install := c.newInstall()
+	install.DryRun = true
+	install.DryRunOption = "client"
+	install.ClientOnly = true
+	install.Replace = true
+	install.IncludeCRDs = true
+	rel, err := install.Run(chartRequested, helmValues.AsMap())
+	if err != nil {
+		klog.ErrorS(err, "Run command failed", "namespace", c.Namespace, "gateway", c.ReleaseName)
+		return "", err
+	}
+
+	var manifest strings.Builder
+	manifest.WriteString(rel.Manifest)
+	for _, hook := range rel.Hooks {
+		fmt.Fprintf(&manifest, "---\n# Source: %s\n%s\n", hook.Path, hook.Manifest)
+	}
+	return manifest.String(), nil
+}
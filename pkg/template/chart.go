@@ -0,0 +1,35 @@
+package template
+
+import (
+	"embed"
+	"errors"
+	"path"
+)
+
+// charts holds the chart tgz of the target version placed in charts/ at build time, see
+// charts/README.md.
+//
+//go:embed charts
+var charts embed.FS
+
+// EmbeddedChart returns the chart tgz built into the binary.
+func EmbeddedChart() ([]byte, error) {
+	entries, err := charts.ReadDir("charts")
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, entry := range entries {
+		if path.Ext(entry.Name()) == ".tgz" {
+			matches = append(matches, path.Join("charts", entry.Name()))
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, errors.New("no chart embedded in this build, pass the chart tgz of the target version with --chart")
+	case 1:
+		return charts.ReadFile(matches[0])
+	default:
+		return nil, errors.New("more than one chart embedded in this build, pass the chart tgz of the target version with --chart")
+	}
+}
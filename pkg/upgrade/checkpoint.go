@@ -0,0 +1,259 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+)
+
+const (
+	// LabelUpgradeRun marks the ConfigMaps holding run records with the run ID.
+	LabelUpgradeRun = "gateway.kubesphere.io/upgrade-run"
+	RunConfigMapKey = "run.yaml"
+)
+
+// Phase is the last step completed for a gateway, the steps run in the order below.
+type Phase string
+
+const (
+	PhasePending  Phase = ""
+	PhaseBackedUp Phase = "backed-up"
+	// PhaseIngressClassDeleting is recorded before the IngressClass is deleted, so a run evicted
+	// right after the deletion still knows the IngressClass and the revision.
+	PhaseIngressClassDeleting Phase = "ingressclass-deleting"
+	PhaseIngressClassDeleted  Phase = "ingressclass-deleted"
+	PhaseCRUpdated            Phase = "cr-updated"
+	PhaseReady                Phase = "ready"
+)
+
+var phaseOrder = map[Phase]int{
+	PhasePending:              0,
+	PhaseBackedUp:             1,
+	PhaseIngressClassDeleting: 2,
+	PhaseIngressClassDeleted:  3,
+	PhaseCRUpdated:            4,
+	PhaseReady:                5,
+}
+
+// Reached reports whether p is phase or a later one.
+func (p Phase) Reached(phase Phase) bool {
+	return phaseOrder[p] >= phaseOrder[phase]
+}
+
+// RunRecord is persisted in a ConfigMap so an interrupted run can be resumed.
+type RunRecord struct {
+	ID        string      `json:"id"`
+	StartTime metav1.Time `json:"startTime"`
+	// Finished is set once every gateway was handled without error.
+	Finished bool `json:"finished,omitempty"`
+	// Gateways are namespace/name in the order given on the command line.
+	Gateways []string `json:"gateways"`
+	// Backup is the backup file written by the run, empty if backups were disabled.
+	Backup  string                    `json:"backup,omitempty"`
+	Records map[string]*GatewayRecord `json:"records"`
+}
+
+type GatewayRecord struct {
+	Phase Phase `json:"phase,omitempty"`
+	// FromVersion is the app version of the gateway when the run was created, a resumed run may
+	// find the CR updated already.
+	FromVersion string `json:"fromVersion,omitempty"`
+	// Revision of the helm release before the CR was updated, needed to wait for the reconcile
+	// when resuming after cr-updated.
+	Revision int `json:"revision,omitempty"`
+	// IngressClass is the name of the IngressClass deleted before the CR was updated, recorded
+	// before it is deleted.
+	IngressClass string `json:"ingressClass,omitempty"`
+}
+
+// Checkpoint stores the RunRecord in a ConfigMap in ExtensionNamespace after every completed phase.
+type Checkpoint struct {
+	client    client.Client
+	configMap *corev1.ConfigMap
+
+	mu     sync.Mutex
+	record *RunRecord
+}
+
+// NewRunID returns an ID sorting by start time.
+func NewRunID() string {
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102150405"), rand.String(5))
+}
+
+func runConfigMapName(runID string) string {
+	return "gateway-upgrade-run-" + runID
+}
+
+// CreateCheckpoint stores a new run record for gateways, backed up to backupPath unless it is empty.
+func CreateCheckpoint(ctx context.Context, c client.Client, gateways []gatewayv2alpha2.Gateway, backupPath string) (*Checkpoint, error) {
+	record := &RunRecord{
+		ID:        NewRunID(),
+		StartTime: metav1.Now(),
+		Gateways:  gatewayNames(gateways),
+		Backup:    backupPath,
+		Records:   map[string]*GatewayRecord{},
+	}
+	phase := PhasePending
+	if backupPath != "" {
+		phase = PhaseBackedUp
+	}
+	for _, gw := range gateways {
+		record.Records[fmt.Sprintf("%s/%s", gw.Namespace, gw.Name)] = &GatewayRecord{Phase: phase, FromVersion: gw.Spec.AppVersion}
+	}
+	cp := &Checkpoint{
+		client: c,
+		record: record,
+		configMap: &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: ExtensionNamespace,
+				Name:      runConfigMapName(record.ID),
+				Labels:    map[string]string{LabelUpgradeRun: record.ID},
+			},
+		},
+	}
+	data, err := yaml.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	cp.configMap.Data = map[string]string{RunConfigMapKey: string(data)}
+	err = c.Create(ctx, cp.configMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to store run record: %w", err)
+	}
+	return cp, nil
+}
+
+// LoadCheckpoint reads the record of an earlier run.
+func LoadCheckpoint(ctx context.Context, c client.Client, runID string) (*Checkpoint, error) {
+	configMap := &corev1.ConfigMap{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: ExtensionNamespace, Name: runConfigMapName(runID)}, configMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get record of run %s: %w", runID, err)
+	}
+	return checkpointOf(c, configMap)
+}
+
+// ListUnfinishedCheckpoints reads the records of the earlier runs which did not finish, oldest first.
+func ListUnfinishedCheckpoints(ctx context.Context, c client.Client) ([]*Checkpoint, error) {
+	configMaps := &corev1.ConfigMapList{}
+	err := c.List(ctx, configMaps, client.InNamespace(ExtensionNamespace), client.HasLabels{LabelUpgradeRun})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list run records: %w", err)
+	}
+	var checkpoints []*Checkpoint
+	for i := range configMaps.Items {
+		checkpoint, err := checkpointOf(c, &configMaps.Items[i])
+		if err != nil {
+			return nil, err
+		}
+		if !checkpoint.Finished() {
+			checkpoints = append(checkpoints, checkpoint)
+		}
+	}
+	sort.Slice(checkpoints, func(i, j int) bool {
+		return checkpoints[i].ID() < checkpoints[j].ID()
+	})
+	return checkpoints, nil
+}
+
+func checkpointOf(c client.Client, configMap *corev1.ConfigMap) (*Checkpoint, error) {
+	runID := configMap.Labels[LabelUpgradeRun]
+	record := &RunRecord{}
+	err := yaml.Unmarshal([]byte(configMap.Data[RunConfigMapKey]), record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse record of run %s: %w", runID, err)
+	}
+	if record.Records == nil {
+		record.Records = map[string]*GatewayRecord{}
+	}
+	return &Checkpoint{client: c, configMap: configMap, record: record}, nil
+}
+
+func (cp *Checkpoint) ID() string {
+	return cp.record.ID
+}
+
+// GatewayReferences returns the gateways of the run.
+func (cp *Checkpoint) GatewayReferences() []*gatewayv2alpha2.GatewayReference {
+	refs := make([]*gatewayv2alpha2.GatewayReference, 0, len(cp.record.Gateways))
+	for _, name := range cp.record.Gateways {
+		namespace, gatewayName, _ := strings.Cut(name, "/")
+		refs = append(refs, &gatewayv2alpha2.GatewayReference{Namespace: namespace, Name: gatewayName})
+	}
+	return refs
+}
+
+func (cp *Checkpoint) Finished() bool {
+	return cp.record.Finished
+}
+
+func (cp *Checkpoint) Backup() string {
+	return cp.record.Backup
+}
+
+// Record returns a copy of the record of the gateway.
+func (cp *Checkpoint) Record(gw *gatewayv2alpha2.Gateway) GatewayRecord {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if record, ok := cp.record.Records[fmt.Sprintf("%s/%s", gw.Namespace, gw.Name)]; ok {
+		return *record
+	}
+	return GatewayRecord{}
+}
+
+// SetBackup records the backup taken when resuming a run which had none, the gateways which were
+// not started yet count as backed up.
+func (cp *Checkpoint) SetBackup(ctx context.Context, path string) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.record.Backup = path
+	for _, record := range cp.record.Records {
+		if record.Phase == PhasePending {
+			record.Phase = PhaseBackedUp
+		}
+	}
+	return cp.save(ctx)
+}
+
+// SetRecord stores the record of the gateway.
+func (cp *Checkpoint) SetRecord(ctx context.Context, gw *gatewayv2alpha2.Gateway, record GatewayRecord) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.record.Records[fmt.Sprintf("%s/%s", gw.Namespace, gw.Name)] = &record
+	return cp.save(ctx)
+}
+
+func (cp *Checkpoint) SetFinished(ctx context.Context) error {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	cp.record.Finished = true
+	return cp.save(ctx)
+}
+
+// save writes the record, it also runs after ctx was cancelled so the last phase is not lost.
+func (cp *Checkpoint) save(ctx context.Context) error {
+	data, err := yaml.Marshal(cp.record)
+	if err != nil {
+		return err
+	}
+	configMap := cp.configMap.DeepCopy()
+	configMap.Data = map[string]string{RunConfigMapKey: string(data)}
+	err = cp.client.Update(context.WithoutCancel(ctx), configMap)
+	if err != nil {
+		return fmt.Errorf("failed to store record of run %s: %w", cp.record.ID, err)
+	}
+	cp.configMap = configMap
+	return nil
+}
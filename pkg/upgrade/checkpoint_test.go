@@ -0,0 +1,138 @@
+package upgrade
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
+)
+
+func TestPhaseReached(t *testing.T) {
+	order := []Phase{PhasePending, PhaseBackedUp, PhaseIngressClassDeleting, PhaseIngressClassDeleted, PhaseCRUpdated, PhaseReady}
+	for i, p := range order {
+		for j, phase := range order {
+			if got, want := p.Reached(phase), i >= j; got != want {
+				t.Errorf("Phase(%q).Reached(%q) = %t, want %t", p, phase, got, want)
+			}
+		}
+	}
+}
+
+func TestCheckpointSaveLoad(t *testing.T) {
+	ctx := context.Background()
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	gw1, gw2 := testGateway("a", "gw1", ""), testGateway("b", "gw2", "")
+	gw1.Spec.AppVersion, gw2.Spec.AppVersion = testFromVersion, testFromVersion
+
+	tests := []struct {
+		name       string
+		backupPath string
+		wantPhase  Phase
+	}{
+		{name: "without backup", wantPhase: PhasePending},
+		{name: "with backup", backupPath: "/backups/gateway-backup-20240101000000.yaml", wantPhase: PhaseBackedUp},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkpoint, err := CreateCheckpoint(ctx, c, []gatewayv2alpha2.Gateway{gw1, gw2}, tt.backupPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			configMap := &corev1.ConfigMap{}
+			err = c.Get(ctx, types.NamespacedName{Namespace: ExtensionNamespace, Name: "gateway-upgrade-run-" + checkpoint.ID()}, configMap)
+			if err != nil {
+				t.Fatalf("run record is not stored: %v", err)
+			}
+			if configMap.Labels[LabelUpgradeRun] != checkpoint.ID() {
+				t.Errorf("run record labels = %v, want %s=%s", configMap.Labels, LabelUpgradeRun, checkpoint.ID())
+			}
+			updated := GatewayRecord{Phase: PhaseCRUpdated, FromVersion: testFromVersion, Revision: 3, IngressClass: "gw1"}
+			if err := checkpoint.SetRecord(ctx, &gw1, updated); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded, err := LoadCheckpoint(ctx, c, checkpoint.ID())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Backup() != tt.backupPath || loaded.Finished() {
+				t.Errorf("loaded backup = %q, finished = %t, want %q, false", loaded.Backup(), loaded.Finished(), tt.backupPath)
+			}
+			refs := loaded.GatewayReferences()
+			if len(refs) != 2 || *refs[0] != (gatewayv2alpha2.GatewayReference{Namespace: "a", Name: "gw1"}) || *refs[1] != (gatewayv2alpha2.GatewayReference{Namespace: "b", Name: "gw2"}) {
+				t.Errorf("loaded gateways = %v, want a/gw1, b/gw2", refs)
+			}
+			if got := loaded.Record(&gw1); !reflect.DeepEqual(got, updated) {
+				t.Errorf("loaded record of gw1 = %+v, want %+v", got, updated)
+			}
+			if got, want := loaded.Record(&gw2), (GatewayRecord{Phase: tt.wantPhase, FromVersion: testFromVersion}); !reflect.DeepEqual(got, want) {
+				t.Errorf("loaded record of gw2 = %+v, want %+v", got, want)
+			}
+
+			// A backup taken when resuming does not move gateways back.
+			if err := loaded.SetBackup(ctx, "/backups/resumed.yaml"); err != nil {
+				t.Fatal(err)
+			}
+			if got := loaded.Record(&gw1).Phase; got != PhaseCRUpdated {
+				t.Errorf("phase of gw1 after SetBackup = %q, want %q", got, PhaseCRUpdated)
+			}
+			if got := loaded.Record(&gw2).Phase; got != PhaseBackedUp {
+				t.Errorf("phase of gw2 after SetBackup = %q, want %q", got, PhaseBackedUp)
+			}
+		})
+	}
+}
+
+func TestResumeAfterCRUpdated(t *testing.T) {
+	ctx := context.Background()
+	// The earlier attempt deleted the IngressClass and updated the CR, so the gateway is at the
+	// target version and the controller recreated the IngressClass.
+	gw := testGateway("a", "gw1", "")
+	gw.Spec.AppVersion = TargetVersion
+	ingressClass := &networkingv1.IngressClass{ObjectMeta: metav1.ObjectMeta{Name: "gw1"}}
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&gw, ingressClass).Build()
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "a", Name: "gw1"}, &gw); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := CreateCheckpoint(ctx, c, []gatewayv2alpha2.Gateway{gw}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := GatewayRecord{Phase: PhaseCRUpdated, FromVersion: testFromVersion, Revision: 3, IngressClass: "gw1"}
+	if err := checkpoint.SetRecord(ctx, &gw, record); err != nil {
+		t.Fatal(err)
+	}
+	runOptions := options.NewRunOptions()
+	runOptions.Wait.ReadyTimeout, runOptions.Wait.SettleDelay = 10*time.Millisecond, 0
+	r := &Runner{Client: c, RunOptions: *runOptions, Kubeconfig: []byte(testKubeconfig), checkpoint: checkpoint}
+
+	result := r.upgradeGateway(ctx, gw)
+	// Without a helm release the wait fails, but the gateway is neither skipped as up to date
+	// nor changed again.
+	if result.Outcome != OutcomeFailed || result.FromVersion != testFromVersion || result.IngressClass != "gw1" {
+		t.Errorf("upgradeGateway() = %+v, want failed waiting for the release of the upgrade from %s", result, testFromVersion)
+	}
+	if err := c.Get(ctx, types.NamespacedName{Name: "gw1"}, &networkingv1.IngressClass{}); err != nil {
+		t.Errorf("IngressClass recreated by the controller was deleted again: %v", err)
+	}
+	live := &gatewayv2alpha2.Gateway{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: "a", Name: "gw1"}, live); err != nil {
+		t.Fatal(err)
+	}
+	if live.ResourceVersion != gw.ResourceVersion {
+		t.Errorf("gateway CR was updated again, resource version %s, want %s", live.ResourceVersion, gw.ResourceVersion)
+	}
+	if got := checkpoint.Record(&gw).Phase; got != PhaseCRUpdated {
+		t.Errorf("phase = %q, want %q", got, PhaseCRUpdated)
+	}
+}
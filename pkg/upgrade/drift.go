@@ -0,0 +1,97 @@
+package upgrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/simple/helmwrapper"
+)
+
+// DetectDrift compares the gateway CR with its deployed helm release and returns the differences,
+// e.g. after someone ran helm upgrade by hand or the gateway controller failed mid-reconcile.
+// An empty result means the release matches the CR.
+func DetectDrift(kubeconfig []byte, gw *gatewayv2alpha2.Gateway) ([]string, error) {
+	wrapper := helmwrapper.NewHelmWrapper(string(kubeconfig), gw.Namespace, gw.Name)
+	rel, err := wrapper.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var drift []string
+	if !MatchesAppVersion(rel, gw.Spec.AppVersion) {
+		drift = append(drift, fmt.Sprintf("release chart is %s but spec.appVersion is %s", AppVersionOf(rel), gw.Spec.AppVersion))
+	}
+
+	releaseValues, err := wrapper.GetValues(false)
+	if err != nil {
+		return nil, err
+	}
+	specValues := map[string]interface{}{}
+	if len(gw.Spec.Values.Raw) > 0 {
+		err = json.Unmarshal(gw.Spec.Values.Raw, &specValues)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Only the values set in the spec count, the gateway controller may add more to the release.
+	if paths := diffPaths("", specValues, map[string]interface{}(releaseValues)); len(paths) > 0 {
+		drift = append(drift, fmt.Sprintf("release values differ from spec.values at %s", strings.Join(paths, ", ")))
+	}
+	return drift, nil
+}
+
+// diffPaths returns the dotted paths of the values set in spec which differ in release. Keys only
+// present in release are ignored, and scalars are compared by their string form, so values the
+// gateway controller adds or normalizes, e.g. a port 80 stored as "80", are not reported.
+func diffPaths(prefix string, spec, release interface{}) []string {
+	path := prefix
+	if path == "" {
+		path = "."
+	}
+	switch specValue := spec.(type) {
+	case map[string]interface{}:
+		releaseMap, ok := release.(map[string]interface{})
+		if !ok {
+			return []string{path}
+		}
+		keys := make([]string, 0, len(specValue))
+		for k := range specValue {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var paths []string
+		for _, k := range keys {
+			keyPath := k
+			if prefix != "" {
+				keyPath = prefix + "." + k
+			}
+			releaseValue, ok := releaseMap[k]
+			if !ok {
+				if specValue[k] != nil {
+					paths = append(paths, keyPath)
+				}
+				continue
+			}
+			paths = append(paths, diffPaths(keyPath, specValue[k], releaseValue)...)
+		}
+		return paths
+	case []interface{}:
+		releaseSlice, ok := release.([]interface{})
+		if !ok || len(releaseSlice) != len(specValue) {
+			return []string{path}
+		}
+		var paths []string
+		for i := range specValue {
+			paths = append(paths, diffPaths(fmt.Sprintf("%s[%d]", prefix, i), specValue[i], releaseSlice[i])...)
+		}
+		return paths
+	default:
+		if spec == nil || fmt.Sprint(spec) == fmt.Sprint(release) {
+			return nil
+		}
+		return []string{path}
+	}
+}
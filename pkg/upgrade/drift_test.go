@@ -0,0 +1,102 @@
+package upgrade
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"helm.sh/helm/v3/pkg/chart"
+	helmrelease "helm.sh/helm/v3/pkg/release"
+)
+
+func TestDiffPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		release string
+		want    []string
+	}{
+		{name: "equal", spec: `{"a":1,"b":{"c":"x"}}`, release: `{"a":1,"b":{"c":"x"}}`},
+		{name: "empty spec", spec: `{}`, release: `{"a":1}`},
+		{name: "keys only in release", spec: `{"b":{"c":"x"}}`, release: `{"a":1,"b":{"c":"x","d":true}}`},
+		{name: "normalized scalar", spec: `{"port":"80","replicas":2}`, release: `{"port":80,"replicas":"2"}`},
+		{name: "changed scalar", spec: `{"b":{"c":"x"}}`, release: `{"b":{"c":"y"}}`, want: []string{"b.c"}},
+		{name: "missing in release", spec: `{"a":1,"b":{"c":"x"}}`, release: `{"b":{}}`, want: []string{"a", "b.c"}},
+		{name: "null in spec", spec: `{"a":null}`, release: `{}`},
+		{name: "map replaced by scalar", spec: `{"b":{"c":"x"}}`, release: `{"b":"x"}`, want: []string{"b"}},
+		{name: "list element", spec: `{"l":[{"n":"a"},{"n":"b"}]}`, release: `{"l":[{"n":"a","x":1},{"n":"c"}]}`, want: []string{"l[1].n"}},
+		{name: "list length", spec: `{"l":["a"]}`, release: `{"l":["a","b"]}`, want: []string{"l"}},
+		{name: "root scalar", spec: `1`, release: `2`, want: []string{"."}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var spec, release interface{}
+			if err := json.Unmarshal([]byte(tt.spec), &spec); err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal([]byte(tt.release), &release); err != nil {
+				t.Fatal(err)
+			}
+			if got := diffPaths("", spec, release); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffPaths() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppVersion(t *testing.T) {
+	release := func(name, version, appVersion string) *helmrelease.Release {
+		return &helmrelease.Release{Chart: &chart.Chart{Metadata: &chart.Metadata{Name: name, Version: version, AppVersion: appVersion}}}
+	}
+	tests := []struct {
+		name           string
+		rel            *helmrelease.Release
+		appVersion     string
+		wantAppVersion string
+		wantMatch      bool
+	}{
+		{
+			name:           "chart name and version",
+			rel:            release("kubesphere-nginx-ingress", "4.12.1", "1.12.1"),
+			appVersion:     TargetVersion,
+			wantAppVersion: TargetVersion,
+			wantMatch:      true,
+		},
+		{
+			name:           "chart version",
+			rel:            release("kubesphere-nginx-ingress", "4.12.1", "1.12.1"),
+			appVersion:     "4.12.1",
+			wantAppVersion: TargetVersion,
+			wantMatch:      true,
+		},
+		{
+			name:           "chart app version",
+			rel:            release("kubesphere-nginx-ingress", "4.12.1", "1.12.1"),
+			appVersion:     "1.12.1",
+			wantAppVersion: TargetVersion,
+			wantMatch:      true,
+		},
+		{
+			name:           "other version",
+			rel:            release("kubesphere-nginx-ingress", "4.4.0", "1.4.0"),
+			appVersion:     TargetVersion,
+			wantAppVersion: "kubesphere-nginx-ingress-4.4.0",
+		},
+		{
+			name:       "no chart metadata",
+			rel:        &helmrelease.Release{},
+			appVersion: TargetVersion,
+			wantMatch:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AppVersionOf(tt.rel); got != tt.wantAppVersion {
+				t.Errorf("AppVersionOf() = %q, want %q", got, tt.wantAppVersion)
+			}
+			if got := MatchesAppVersion(tt.rel, tt.appVersion); got != tt.wantMatch {
+				t.Errorf("MatchesAppVersion(%q) = %t, want %t", tt.appVersion, got, tt.wantMatch)
+			}
+		})
+	}
+}
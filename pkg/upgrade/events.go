@@ -0,0 +1,126 @@
+package upgrade
+
+import (
+	"sync/atomic"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
+)
+
+// EventComponent is the source of the Events emitted on the gateways.
+const EventComponent = "gateway-upgrade-tool"
+
+// Reasons of the Events emitted on the gateways, shown by kubectl describe gateway.
+const (
+	EventReasonUpgradeStarted       = "UpgradeStarted"
+	EventReasonUpgradeSkipped       = "UpgradeSkipped"
+	EventReasonIngressClassReplaced = "IngressClassReplaced"
+	EventReasonGatewayUpdated       = "GatewayUpdated"
+	EventReasonReleaseReady         = "ReleaseReady"
+	EventReasonUpgradeFailed        = "UpgradeFailed"
+)
+
+// EventFlushTimeout bounds the wait for the queued Events when the run ends.
+const EventFlushTimeout = 5 * time.Second
+
+// EventBroadcaster sends the Events of its recorders to the cluster. It counts the Events
+// emitted and sent, so Shutdown can wait for the queued ones, the Shutdown of client-go drops them.
+type EventBroadcaster struct {
+	broadcaster record.EventBroadcaster
+	emitted     atomic.Int64
+	sent        atomic.Int64
+}
+
+// NewEventBroadcaster returns a broadcaster sending Events to the cluster of config.
+func NewEventBroadcaster(config *rest.Config) (*EventBroadcaster, error) {
+	clientSet, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return newEventBroadcaster(&typedcorev1.EventSinkImpl{Interface: clientSet.CoreV1().Events("")}), nil
+}
+
+func newEventBroadcaster(sink record.EventSink) *EventBroadcaster {
+	b := &EventBroadcaster{broadcaster: record.NewBroadcaster()}
+	b.broadcaster.StartRecordingToSink(&countingSink{EventSink: sink, sent: &b.sent})
+	return b
+}
+
+// NewRecorder returns a recorder emitting Events from EventComponent.
+func (b *EventBroadcaster) NewRecorder() record.EventRecorder {
+	return &countingRecorder{
+		EventRecorder: b.broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: EventComponent}),
+		emitted:       &b.emitted,
+	}
+}
+
+// Shutdown waits up to timeout for the emitted Events to be sent and stops the broadcaster. Events
+// the correlator drops as spam are never sent, the wait ends at the timeout then.
+func (b *EventBroadcaster) Shutdown(timeout time.Duration) {
+	defer b.broadcaster.Shutdown()
+	deadline := time.Now().Add(timeout)
+	for b.sent.Load() < b.emitted.Load() && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+type countingRecorder struct {
+	record.EventRecorder
+	emitted *atomic.Int64
+}
+
+func (r *countingRecorder) Event(object runtime.Object, eventType, reason, message string) {
+	r.emitted.Add(1)
+	r.EventRecorder.Event(object, eventType, reason, message)
+}
+
+func (r *countingRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	r.emitted.Add(1)
+	r.EventRecorder.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+func (r *countingRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+	r.emitted.Add(1)
+	r.EventRecorder.AnnotatedEventf(object, annotations, eventType, reason, messageFmt, args...)
+}
+
+// countingSink counts the Events written, an Event aggregated by the correlator is patched.
+type countingSink struct {
+	record.EventSink
+	sent *atomic.Int64
+}
+
+func (s *countingSink) Create(event *corev1.Event) (*corev1.Event, error) {
+	return s.count(s.EventSink.Create(event))
+}
+
+func (s *countingSink) Update(event *corev1.Event) (*corev1.Event, error) {
+	return s.count(s.EventSink.Update(event))
+}
+
+func (s *countingSink) Patch(oldEvent *corev1.Event, data []byte) (*corev1.Event, error) {
+	return s.count(s.EventSink.Patch(oldEvent, data))
+}
+
+func (s *countingSink) count(event *corev1.Event, err error) (*corev1.Event, error) {
+	if err == nil {
+		s.sent.Add(1)
+	}
+	return event, err
+}
+
+// event emits an Event on the gateway, if the runner has a recorder.
+func (r *Runner) event(gw *gatewayv2alpha2.Gateway, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder == nil {
+		return
+	}
+	r.Recorder.Eventf(gw, eventType, reason, messageFmt, args...)
+}
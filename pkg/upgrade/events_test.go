@@ -0,0 +1,68 @@
+package upgrade
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+)
+
+// slowSink takes a while to write every Event, like an API server under load.
+type slowSink struct {
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (s *slowSink) Create(event *corev1.Event) (*corev1.Event, error) {
+	time.Sleep(20 * time.Millisecond)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reasons = append(s.reasons, event.Reason)
+	return event, nil
+}
+
+func (s *slowSink) Update(event *corev1.Event) (*corev1.Event, error) {
+	return s.Create(event)
+}
+
+func (s *slowSink) Patch(event *corev1.Event, _ []byte) (*corev1.Event, error) {
+	return s.Create(event)
+}
+
+func TestEventBroadcasterShutdownFlushes(t *testing.T) {
+	sink := &slowSink{}
+	broadcaster := newEventBroadcaster(sink)
+	r := &Runner{Recorder: broadcaster.NewRecorder()}
+	reasons := []string{EventReasonUpgradeStarted, EventReasonIngressClassReplaced, EventReasonGatewayUpdated, EventReasonReleaseReady}
+	for i := 0; i < 3; i++ {
+		gw := &gatewayv2alpha2.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "gw" + string(rune('a'+i))}}
+		for _, reason := range reasons {
+			r.event(gw, corev1.EventTypeNormal, reason, "gateway %s", gw.Name)
+		}
+	}
+
+	broadcaster.Shutdown(5 * time.Second)
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if got, want := len(sink.reasons), 3*len(reasons); got != want {
+		t.Fatalf("sent %d events, want %d", got, want)
+	}
+	if last := sink.reasons[len(sink.reasons)-1]; last != EventReasonReleaseReady {
+		t.Errorf("last event is %s, want %s", last, EventReasonReleaseReady)
+	}
+}
+
+func TestEventBroadcasterShutdownTimeout(t *testing.T) {
+	broadcaster := newEventBroadcaster(&slowSink{})
+	// An Event which is never sent must not block the exit.
+	broadcaster.emitted.Add(1)
+	start := time.Now()
+	broadcaster.Shutdown(100 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Shutdown took %s", elapsed)
+	}
+}
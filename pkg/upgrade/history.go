@@ -0,0 +1,94 @@
+package upgrade
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/history"
+)
+
+// RecordHistory appends the upgraded and failed gateways to the history ledger. A gateway upgraded
+// by an earlier attempt of a resumed run is only appended if that attempt did not record it. A
+// ledger which cannot be written does not fail the run.
+func (r *Runner) RecordHistory(ctx context.Context, results []GatewayResult) {
+	// Interrupted runs are recorded too.
+	ctx = context.WithoutCancel(ctx)
+	ledger := history.New(r.Client, ExtensionNamespace)
+	recorded := r.recordedGateways(ctx, ledger, results)
+
+	user := history.User(ctx, r.Client)
+	var entries []history.Entry
+	for _, result := range results {
+		if result.EarlierAttempt && recorded[result.FullName()] {
+			continue
+		}
+		var outcome string
+		switch result.Outcome {
+		case OutcomeUpgraded:
+			outcome = history.OutcomeSucceeded
+		case OutcomeFailed:
+			outcome = history.OutcomeFailed
+		default:
+			continue
+		}
+		entry := history.NewEntry(history.OperationUpgrade, result.FullName(), result.Start, result.Duration)
+		entry.User = user
+		entry.Outcome = outcome
+		entry.Reason = result.Reason
+		entry.FromVersion = result.FromVersion
+		if r.checkpoint != nil {
+			entry.RunID = r.checkpoint.ID()
+			entry.Backup = r.checkpoint.Backup()
+		}
+		live := &gatewayv2alpha2.Gateway{}
+		err := r.Client.Get(ctx, types.NamespacedName{Namespace: result.Namespace, Name: result.Name}, live)
+		if err != nil {
+			klog.ErrorS(err, "Failed to get gateway for the history", r.logKeys(result.Namespace, result.Name)...)
+		} else {
+			entry.ToVersion = live.Spec.AppVersion
+			entry.ValuesHash = history.ValuesHash(live.Spec.Values.Raw)
+		}
+		entries = append(entries, entry)
+	}
+
+	err := ledger.Append(ctx, entries...)
+	if err != nil {
+		klog.ErrorS(err, "Failed to record the upgrade history", r.logKeys("", "")...)
+	}
+}
+
+// recordedGateways returns the gateways whose upgrade the ledger records for the run already. It
+// is only read if an earlier attempt upgraded some of the results, and if it can't be read every
+// gateway counts as recorded so none is recorded twice.
+func (r *Runner) recordedGateways(ctx context.Context, ledger *history.Ledger, results []GatewayResult) map[string]bool {
+	recorded := map[string]bool{}
+	if r.checkpoint == nil {
+		return recorded
+	}
+	var earlier []string
+	for _, result := range results {
+		if result.EarlierAttempt {
+			earlier = append(earlier, result.FullName())
+		}
+	}
+	if len(earlier) == 0 {
+		return recorded
+	}
+	entries, err := ledger.List(ctx)
+	if err != nil {
+		klog.ErrorS(err, "Failed to read the upgrade history, skip the gateways upgraded by an earlier attempt", r.logKeys("", "")...)
+		for _, name := range earlier {
+			recorded[name] = true
+		}
+		return recorded
+	}
+	for _, entry := range entries {
+		if entry.RunID == r.checkpoint.ID() && entry.Operation == history.OperationUpgrade && entry.Outcome == history.OutcomeSucceeded {
+			recorded[entry.Gateway] = true
+		}
+	}
+	return recorded
+}
@@ -0,0 +1,96 @@
+package upgrade
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/history"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
+)
+
+const testFromVersion = "kubesphere-nginx-ingress-4.4.0"
+
+func TestResumedRunHistory(t *testing.T) {
+	ctx := context.Background()
+	// Both gateways were upgraded by an earlier attempt, which recorded gw1 but was evicted before
+	// recording gw2.
+	gw1, gw2 := testGateway("a", "gw1", ""), testGateway("a", "gw2", "")
+	gw1.Spec.AppVersion, gw2.Spec.AppVersion = testFromVersion, testFromVersion
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+	checkpoint, err := CreateCheckpoint(ctx, c, []gatewayv2alpha2.Gateway{gw1, gw2}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, gw := range []*gatewayv2alpha2.Gateway{&gw1, &gw2} {
+		record := checkpoint.Record(gw)
+		record.Phase = PhaseReady
+		if err := checkpoint.SetRecord(ctx, gw, record); err != nil {
+			t.Fatal(err)
+		}
+		gw.Spec.AppVersion = TargetVersion
+		if err := c.Create(ctx, gw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recorded := history.NewEntry(history.OperationUpgrade, "a/gw1", time.Now(), time.Minute)
+	recorded.RunID, recorded.Outcome = checkpoint.ID(), history.OutcomeSucceeded
+	ledger := history.New(c, ExtensionNamespace)
+	if err := ledger.Append(ctx, recorded); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{Client: c, RunOptions: *options.NewRunOptions(), checkpoint: checkpoint}
+	var results []GatewayResult
+	for _, gw := range []gatewayv2alpha2.Gateway{gw1, gw2} {
+		result := r.upgradeGateway(ctx, gw)
+		if result.Outcome != OutcomeUpgraded || !result.EarlierAttempt || result.FromVersion != testFromVersion {
+			t.Errorf("upgradeGateway(%s) = %+v, want upgraded by an earlier attempt from %s", gw.Name, result, testFromVersion)
+		}
+		results = append(results, result)
+	}
+	r.RecordHistory(ctx, results)
+
+	entries, err := ledger.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("ledger has %d entries, want the one of gw1 and one added for gw2: %+v", len(entries), entries)
+	}
+	added := entries[1]
+	if added.Gateway != "a/gw2" || added.FromVersion != testFromVersion || added.ToVersion != TargetVersion || added.RunID != checkpoint.ID() {
+		t.Errorf("added entry = %+v, want a/gw2 from %s to %s", added, testFromVersion, TargetVersion)
+	}
+}
+
+func TestRecordHistoryTiming(t *testing.T) {
+	ctx := context.Background()
+	gw := testGateway("a", "gw1", "")
+	gw.Spec.AppVersion = TargetVersion
+	c := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&gw).Build()
+	r := &Runner{Client: c, RunOptions: *options.NewRunOptions()}
+	// The gateway was upgraded in the first wave, long before the run ended.
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+	r.RecordHistory(ctx, []GatewayResult{
+		{Namespace: "a", Name: "gw1", Outcome: OutcomeUpgraded, FromVersion: testFromVersion, Start: start, Duration: 90 * time.Second},
+	})
+
+	entries, err := history.New(c, ExtensionNamespace).List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("ledger has %d entries, want 1", len(entries))
+	}
+	if got, want := entries[0].Time.Time, start.Add(90*time.Second); !got.Equal(want) {
+		t.Errorf("entry time = %s, want the end of the gateway upgrade %s", got, want)
+	}
+	if got := entries[0].Duration.Duration; got != 90*time.Second {
+		t.Errorf("entry duration = %s, want 1m30s", got)
+	}
+}
@@ -0,0 +1,216 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/simple/helmwrapper"
+)
+
+type CheckStatus string
+
+const (
+	CheckPass CheckStatus = "PASS"
+	CheckWarn CheckStatus = "WARN"
+	CheckFail CheckStatus = "FAIL"
+)
+
+type CheckResult struct {
+	Name string
+	// Target is the checked gateway, empty for cluster wide checks
+	Target  string
+	Status  CheckStatus
+	Message string
+	// Remedy explains how to fix a failed check, printed after the result table
+	Remedy string
+}
+
+// Preflight checks everything the upgrade relies on before anything is changed, so a broken gateway
+// fails the run up front instead of after earlier gateways were already upgraded.
+func (r *Runner) Preflight(ctx context.Context, gateways []gatewayv2alpha2.Gateway) []CheckResult {
+	results := []CheckResult{
+		r.checkGatewayCRD(),
+		r.checkGatewayConfig(ctx),
+		r.checkPermissions(ctx, gateways),
+	}
+	runs, err := ListUnfinishedCheckpoints(ctx, r.Client)
+	if err != nil {
+		result := CheckResult{Name: "upgrade-in-progress"}
+		results = append(results, result.fail(err.Error()))
+	}
+	for i := range gateways {
+		results = append(results, r.checkGateway(ctx, &gateways[i], runs)...)
+	}
+	return results
+}
+
+func (r *Runner) checkGatewayCRD() CheckResult {
+	result := CheckResult{Name: "gateway-crd"}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(r.RestConfig)
+	if err != nil {
+		return result.fail(err.Error())
+	}
+	resources, err := discoveryClient.ServerResourcesForGroupVersion(gatewayv2alpha2.SchemeGroupVersion.String())
+	if err != nil {
+		return result.fail(fmt.Sprintf("%s is not served: %v", gatewayv2alpha2.SchemeGroupVersion, err))
+	}
+	for _, resource := range resources.APIResources {
+		if resource.Name == gatewayv2alpha2.GatewayResource {
+			return result.pass(fmt.Sprintf("%s is served at %s", gatewayv2alpha2.GatewayResource, gatewayv2alpha2.SchemeGroupVersion))
+		}
+	}
+	return result.fail(fmt.Sprintf("%s is not served at %s", gatewayv2alpha2.GatewayResource, gatewayv2alpha2.SchemeGroupVersion))
+}
+
+func (r *Runner) checkGatewayConfig(ctx context.Context) CheckResult {
+	result := CheckResult{Name: "gateway-config"}
+	config, err := GetGatewayConfig(ctx, r.Client)
+	if err != nil {
+		return result.fail(fmt.Sprintf("failed to get %s/%s: %v", ExtensionNamespace, GatewayConfigMapName, err))
+	}
+	err = yaml.Unmarshal([]byte(config), &GatewayConfig{})
+	if err != nil {
+		return result.fail(fmt.Sprintf("failed to parse config.yaml of %s/%s: %v", ExtensionNamespace, GatewayConfigMapName, err))
+	}
+	return result.pass(fmt.Sprintf("%s/%s parsed", ExtensionNamespace, GatewayConfigMapName))
+}
+
+// checkGateway checks a gateway, runs are the earlier runs which did not finish.
+func (r *Runner) checkGateway(ctx context.Context, gw *gatewayv2alpha2.Gateway, runs []*Checkpoint) []CheckResult {
+	target := fmt.Sprintf("%s/%s", gw.Namespace, gw.Name)
+	if phase := r.record(gw).Phase; phase.Reached(PhaseIngressClassDeleting) {
+		result := CheckResult{Name: "resume", Target: target}
+		return []CheckResult{result.pass(fmt.Sprintf("the gateway continues from phase %s", phase))}
+	}
+	if !r.isRequiredVersion(gw.Spec.AppVersion) {
+		result := CheckResult{Name: "app-version", Target: target}
+		return []CheckResult{result.warn(fmt.Sprintf("app version is %s, the gateway will be skipped", gw.Spec.AppVersion))}
+	}
+
+	results := make([]CheckResult, 0, 6)
+
+	results = append(results, r.checkInProgress(gw, runs))
+
+	result := CheckResult{Name: "deployed", Target: target}
+	if gw.IsDeployed() {
+		results = append(results, result.pass("condition Deployed is True"))
+	} else {
+		results = append(results, result.warn("condition Deployed is not True, the gateway will be skipped"))
+	}
+
+	result = CheckResult{Name: "deployment-ready", Target: target}
+	if gw.IsDeploymentReady() {
+		results = append(results, result.pass("condition DeploymentReady is True"))
+	} else {
+		results = append(results, result.warn("condition DeploymentReady is not True, the gateway will be skipped"))
+	}
+	// The service, ingress class and release of a skipped gateway are left alone, a gateway which
+	// is not deployed yet has none of them and must not fail the run.
+	if !gw.IsDeployed() || !gw.IsDeploymentReady() {
+		return results
+	}
+
+	result = CheckResult{Name: "service", Target: target}
+	service := &corev1.Service{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: gw.Namespace, Name: gw.Name}, service)
+	if err != nil {
+		results = append(results, result.fail(fmt.Sprintf("failed to get service: %v", err)))
+	} else {
+		results = append(results, result.pass(fmt.Sprintf("service type %s", service.Spec.Type)))
+	}
+
+	result = CheckResult{Name: "ingressclass", Target: target}
+	ingressClassList := &v1.IngressClassList{}
+	err = r.Client.List(ctx, ingressClassList, client.MatchingLabels{"app.kubernetes.io/instance": gw.Name})
+	switch {
+	case err != nil:
+		results = append(results, result.fail(fmt.Sprintf("failed to list ingress classes: %v", err)))
+	case len(ingressClassList.Items) == 0:
+		results = append(results, result.fail("no ingress class found"))
+	default:
+		results = append(results, result.pass(ingressClassList.Items[0].Name))
+	}
+
+	result = CheckResult{Name: "helm-release", Target: target}
+	rel, err := helmwrapper.NewHelmWrapper(string(r.Kubeconfig), gw.Namespace, gw.Name).Status()
+	switch {
+	case err != nil:
+		results = append(results, result.fail(fmt.Sprintf("failed to get release: %v", err)))
+	case rel.Info == nil || rel.Info.Status != helmrelease.StatusDeployed:
+		results = append(results, result.fail(fmt.Sprintf("release revision %d is not deployed", rel.Version)))
+	default:
+		results = append(results, result.pass(fmt.Sprintf("revision %d deployed", rel.Version)))
+	}
+	return results
+}
+
+// checkInProgress fails if an earlier run which did not finish left the gateway half upgraded,
+// upgrading it from scratch would miss the IngressClass that run deleted. The lease keeps other runs
+// from being in progress right now.
+func (r *Runner) checkInProgress(gw *gatewayv2alpha2.Gateway, runs []*Checkpoint) CheckResult {
+	result := CheckResult{Name: "upgrade-in-progress", Target: fmt.Sprintf("%s/%s", gw.Namespace, gw.Name)}
+	for _, run := range runs {
+		if r.checkpoint != nil && run.ID() == r.checkpoint.ID() {
+			continue
+		}
+		if phase := run.Record(gw).Phase; phase.Reached(PhaseIngressClassDeleting) && !phase.Reached(PhaseReady) {
+			result.Remedy = fmt.Sprintf("Continue the upgrade of %s with --resume %s.", result.Target, run.ID())
+			return result.fail(fmt.Sprintf("run %s stopped at phase %s", run.ID(), phase))
+		}
+	}
+	return result.pass("no earlier run stopped during the upgrade")
+}
+
+func (c CheckResult) pass(message string) CheckResult {
+	c.Status, c.Message = CheckPass, message
+	return c
+}
+
+func (c CheckResult) warn(message string) CheckResult {
+	c.Status, c.Message = CheckWarn, message
+	return c
+}
+
+func (c CheckResult) fail(message string) CheckResult {
+	c.Status, c.Message = CheckFail, message
+	return c
+}
+
+func FailedChecks(results []CheckResult) []CheckResult {
+	var failed []CheckResult
+	for _, result := range results {
+		if result.Status == CheckFail {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+func PrintCheckResults(out io.Writer, results []CheckResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tGATEWAY\tRESULT\tMESSAGE")
+	for _, result := range results {
+		target := result.Target
+		if target == "" {
+			target = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Name, target, result.Status, result.Message)
+	}
+	w.Flush()
+	for _, result := range results {
+		if result.Status != CheckPass && result.Remedy != "" {
+			fmt.Fprintf(out, "\n%s\n", result.Remedy)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package upgrade
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
+)
+
+func TestFailedChecks(t *testing.T) {
+	pass := CheckResult{Name: "crd", Status: CheckPass}
+	warn := CheckResult{Name: "resume", Target: "a/gw1", Status: CheckWarn}
+	fail := CheckResult{Name: "release", Target: "a/gw2", Status: CheckFail}
+	tests := []struct {
+		name    string
+		results []CheckResult
+		want    []CheckResult
+	}{
+		{name: "none"},
+		{name: "all passed", results: []CheckResult{pass, warn}},
+		{name: "failed", results: []CheckResult{pass, fail, warn, fail}, want: []CheckResult{fail, fail}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FailedChecks(tt.results); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FailedChecks() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintCheckResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []CheckResult
+		want    string
+	}{
+		{
+			name: "header only",
+			want: "CHECK  GATEWAY  RESULT  MESSAGE\n",
+		},
+		{
+			name: "remedies of warnings and failures",
+			results: []CheckResult{
+				{Name: "crd", Status: CheckPass, Message: "installed", Remedy: "not printed"},
+				{Name: "release", Target: "a/gw1", Status: CheckFail, Message: "revision 3 is not deployed", Remedy: "Rollback the release."},
+				{Name: "resume", Target: "a/gw2", Status: CheckWarn, Message: "phase cr-updated"},
+			},
+			want: "CHECK    GATEWAY  RESULT  MESSAGE\n" +
+				"crd      -        PASS    installed\n" +
+				"release  a/gw1    FAIL    revision 3 is not deployed\n" +
+				"resume   a/gw2    WARN    phase cr-updated\n" +
+				"\nRollback the release.\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			PrintCheckResults(&out, tt.results)
+			if out.String() != tt.want {
+				t.Errorf("PrintCheckResults() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckInProgress(t *testing.T) {
+	tests := []struct {
+		name       string
+		phase      Phase
+		finished   bool
+		current    bool
+		wantStatus CheckStatus
+	}{
+		{name: "not started", phase: PhaseBackedUp, wantStatus: CheckPass},
+		{name: "stopped during the upgrade", phase: PhaseIngressClassDeleted, wantStatus: CheckFail},
+		{name: "upgraded", phase: PhaseReady, wantStatus: CheckPass},
+		{name: "finished run", phase: PhaseIngressClassDeleted, finished: true, wantStatus: CheckPass},
+		{name: "resumed run", phase: PhaseIngressClassDeleted, current: true, wantStatus: CheckPass},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+			gw := testGateway("a", "gw1", "")
+			gw.Spec.AppVersion = testFromVersion
+			run, err := CreateCheckpoint(ctx, c, []gatewayv2alpha2.Gateway{gw}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := run.SetRecord(ctx, &gw, GatewayRecord{Phase: tt.phase, FromVersion: testFromVersion}); err != nil {
+				t.Fatal(err)
+			}
+			if tt.finished {
+				if err := run.SetFinished(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			r := &Runner{Client: c}
+			if tt.current {
+				r.checkpoint = run
+			}
+
+			runs, err := ListUnfinishedCheckpoints(ctx, c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := r.checkInProgress(&gw, runs)
+			if got.Status != tt.wantStatus {
+				t.Errorf("checkInProgress() = %+v, want %s", got, tt.wantStatus)
+			}
+			if want := "Continue the upgrade of a/gw1 with --resume " + run.ID() + "."; got.Status == CheckFail && got.Remedy != want {
+				t.Errorf("remedy = %q, want %q", got.Remedy, want)
+			}
+		})
+	}
+}
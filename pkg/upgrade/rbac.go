@@ -0,0 +1,222 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
+)
+
+// ClusterRoleName is the name of the ClusterRole suggested when permissions are missing.
+const ClusterRoleName = "gateway-upgrade-tool"
+
+type Permission struct {
+	Group    string
+	Resource string
+	Verb     string
+	// Namespace is empty for cluster scoped resources
+	Namespace string
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource = p.Resource + "." + p.Group
+	}
+	if p.Namespace == "" {
+		return fmt.Sprintf("%s %s", p.Verb, resource)
+	}
+	return fmt.Sprintf("%s %s in %s", p.Verb, resource, p.Namespace)
+}
+
+type requiredPermission struct {
+	Group    string
+	Resource string
+	Verbs    []string
+}
+
+var (
+	// gatewayPermissions are needed in every namespace holding an upgraded gateway. The secrets
+	// hold the helm release storage, deployments and replicasets are read while waiting for the release,
+	// events are emitted on the gateways.
+	gatewayPermissions = []requiredPermission{
+		{Group: gatewayv2alpha2.SchemeGroupVersion.Group, Resource: gatewayv2alpha2.GatewayResource, Verbs: []string{"get", "list", "update"}},
+		{Group: "", Resource: "services", Verbs: []string{"get"}},
+		{Group: "", Resource: "secrets", Verbs: []string{"get", "list", "create", "update", "delete"}},
+		{Group: "apps", Resource: "deployments", Verbs: []string{"get", "list", "watch"}},
+		{Group: "apps", Resource: "replicasets", Verbs: []string{"list"}},
+		{Group: "", Resource: "events", Verbs: []string{"create", "patch"}},
+	}
+	// The lease is the run lock, see lock.Lock. The run records are ConfigMaps, see Checkpoint.
+	extensionPermissions = []requiredPermission{
+		{Group: "", Resource: "configmaps", Verbs: []string{"get", "list", "create", "update"}},
+		{Group: "coordination.k8s.io", Resource: "leases", Verbs: []string{"get", "create", "update"}},
+	}
+	clusterPermissions = []requiredPermission{
+		{Group: "networking.k8s.io", Resource: "ingressclasses", Verbs: []string{"list", "delete"}},
+	}
+	// The smoke tests list the Ingresses of all namespaces with --smoke-test-ingresses and the nodes
+	// with --smoke-test-via=nodeport.
+	smokeIngressPermissions  = []requiredPermission{{Group: "networking.k8s.io", Resource: "ingresses", Verbs: []string{"list"}}}
+	smokeNodePortPermissions = []requiredPermission{{Group: "", Resource: "nodes", Verbs: []string{"list"}}}
+)
+
+// smokePermissions returns the cluster scoped permissions of the smoke tests configured by smokeOptions.
+func smokePermissions(smokeOptions *options.SmokeTestOptions) []requiredPermission {
+	var required []requiredPermission
+	if !SmokeTestEnabled(smokeOptions) {
+		return required
+	}
+	if smokeOptions.FromIngresses {
+		required = append(required, smokeIngressPermissions...)
+	}
+	if smokeOptions.Via == SmokeTestViaNodePort && smokeOptions.Address == "" {
+		required = append(required, smokeNodePortPermissions...)
+	}
+	return required
+}
+
+// RequiredPermissions returns every permission the runner and the helm wrapper use to upgrade gateways,
+// and the smoke tests configured by smokeOptions use to test them.
+func RequiredPermissions(gateways []gatewayv2alpha2.Gateway, smokeOptions *options.SmokeTestOptions) []Permission {
+	var permissions []Permission
+	add := func(required []requiredPermission, namespace string) {
+		for _, p := range required {
+			for _, verb := range p.Verbs {
+				permissions = append(permissions, Permission{Group: p.Group, Resource: p.Resource, Verb: verb, Namespace: namespace})
+			}
+		}
+	}
+
+	namespaces := map[string]struct{}{}
+	for _, gw := range gateways {
+		if _, ok := namespaces[gw.Namespace]; ok {
+			continue
+		}
+		namespaces[gw.Namespace] = struct{}{}
+		add(gatewayPermissions, gw.Namespace)
+	}
+	add(extensionPermissions, ExtensionNamespace)
+	add(clusterPermissions, "")
+	add(smokePermissions(smokeOptions), "")
+	return permissions
+}
+
+// MissingPermissions asks the API server through SelfSubjectAccessReviews which of the required
+// permissions the current identity lacks.
+func (r *Runner) MissingPermissions(ctx context.Context, gateways []gatewayv2alpha2.Gateway) ([]Permission, error) {
+	var missing []Permission
+	for _, p := range RequiredPermissions(gateways, r.RunOptions.SmokeTest) {
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: p.Namespace,
+					Verb:      p.Verb,
+					Group:     p.Group,
+					Resource:  p.Resource,
+				},
+			},
+		}
+		err := r.Client.Create(ctx, review)
+		if err != nil {
+			return nil, err
+		}
+		if !review.Status.Allowed {
+			missing = append(missing, p)
+		}
+	}
+	return missing, nil
+}
+
+func (r *Runner) checkPermissions(ctx context.Context, gateways []gatewayv2alpha2.Gateway) CheckResult {
+	result := CheckResult{Name: "rbac"}
+	missing, err := r.MissingPermissions(ctx, gateways)
+	if err != nil {
+		return result.fail(fmt.Sprintf("failed to review permissions: %v", err))
+	}
+	if len(missing) == 0 {
+		return result.pass("all required permissions granted")
+	}
+	names := make([]string, 0, len(missing))
+	for _, p := range missing {
+		names = append(names, p.String())
+	}
+	result = result.fail(fmt.Sprintf("missing permissions: %s", strings.Join(names, ", ")))
+	clusterRole, err := ClusterRoleFor(missing)
+	if err != nil {
+		return result
+	}
+	result.Remedy = "Grant the missing permissions with the following ClusterRole:\n" + clusterRole
+	return result
+}
+
+// JobPermissions returns every permission needed to upgrade gateways in any namespace with
+// runOptions, they are granted to the ServiceAccount of the upgrade Job.
+func JobPermissions(runOptions *options.RunOptions) []Permission {
+	var permissions []Permission
+	for _, required := range [][]requiredPermission{gatewayPermissions, extensionPermissions, clusterPermissions, smokePermissions(runOptions.SmokeTest)} {
+		for _, p := range required {
+			for _, verb := range p.Verbs {
+				permissions = append(permissions, Permission{Group: p.Group, Resource: p.Resource, Verb: verb})
+			}
+		}
+	}
+	return permissions
+}
+
+// ClusterRoleFor returns a ClusterRole manifest granting the permissions.
+func ClusterRoleFor(permissions []Permission) (string, error) {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(NewClusterRole(permissions))
+	if err != nil {
+		return "", err
+	}
+	unstructured.RemoveNestedField(obj, "metadata", "creationTimestamp")
+	out, err := yaml.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// NewClusterRole returns a ClusterRole granting the permissions. A ClusterRole is used even for
+// namespaced permissions, gateways live in any namespace.
+func NewClusterRole(permissions []Permission) *rbacv1.ClusterRole {
+	verbs := map[string]map[string]struct{}{}
+	for _, p := range permissions {
+		key := p.Group + "/" + p.Resource
+		if verbs[key] == nil {
+			verbs[key] = map[string]struct{}{}
+		}
+		verbs[key][p.Verb] = struct{}{}
+	}
+	keys := make([]string, 0, len(verbs))
+	for key := range verbs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	clusterRole := &rbacv1.ClusterRole{
+		TypeMeta:   metav1.TypeMeta{APIVersion: rbacv1.SchemeGroupVersion.String(), Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{Name: ClusterRoleName},
+	}
+	for _, key := range keys {
+		group, resource, _ := strings.Cut(key, "/")
+		rule := rbacv1.PolicyRule{APIGroups: []string{group}, Resources: []string{resource}}
+		for verb := range verbs[key] {
+			rule.Verbs = append(rule.Verbs, verb)
+		}
+		sort.Strings(rule.Verbs)
+		clusterRole.Rules = append(clusterRole.Rules, rule)
+	}
+	return clusterRole
+}
@@ -0,0 +1,74 @@
+package upgrade
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
+)
+
+func TestRequiredPermissions(t *testing.T) {
+	listIngresses := Permission{Group: "networking.k8s.io", Resource: "ingresses", Verb: "list"}
+	listNodes := Permission{Resource: "nodes", Verb: "list"}
+	gateways := []gatewayv2alpha2.Gateway{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "gw1"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "a", Name: "gw2"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "b", Name: "gw3"}},
+	}
+	tests := []struct {
+		name  string
+		smoke *options.SmokeTestOptions
+		want  []Permission
+		not   []Permission
+	}{
+		{name: "no smoke tests", not: []Permission{listIngresses, listNodes}},
+		{
+			name:  "disabled smoke tests",
+			smoke: &options.SmokeTestOptions{Via: SmokeTestViaNodePort},
+			not:   []Permission{listIngresses, listNodes},
+		},
+		{
+			name:  "ingresses via cluster IP",
+			smoke: &options.SmokeTestOptions{FromIngresses: true, Via: SmokeTestViaClusterIP},
+			want:  []Permission{listIngresses},
+			not:   []Permission{listNodes},
+		},
+		{
+			name:  "node port",
+			smoke: &options.SmokeTestOptions{Tests: []string{"example.com"}, Via: SmokeTestViaNodePort},
+			want:  []Permission{listNodes},
+			not:   []Permission{listIngresses},
+		},
+		{
+			name:  "node port with an address",
+			smoke: &options.SmokeTestOptions{Tests: []string{"example.com"}, Via: SmokeTestViaNodePort, Address: "10.0.0.1:80"},
+			not:   []Permission{listNodes},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[Permission]bool{}
+			for _, p := range RequiredPermissions(gateways, tt.smoke) {
+				got[p] = true
+			}
+			for _, namespace := range []string{"a", "b"} {
+				p := Permission{Group: gatewayv2alpha2.SchemeGroupVersion.Group, Resource: gatewayv2alpha2.GatewayResource, Verb: "update", Namespace: namespace}
+				if !got[p] {
+					t.Errorf("missing %s", p)
+				}
+			}
+			for _, p := range tt.want {
+				if !got[p] {
+					t.Errorf("missing %s", p)
+				}
+			}
+			for _, p := range tt.not {
+				if got[p] {
+					t.Errorf("unexpected %s", p)
+				}
+			}
+		})
+	}
+}
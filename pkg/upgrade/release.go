@@ -0,0 +1,234 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	helmrelease "helm.sh/helm/v3/pkg/release"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/metrics"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/simple/helmwrapper"
+)
+
+// DeleteIngressClass deletes the IngressClass created by the gateway release and returns its name.
+// The controller value of an IngressClass is immutable, so it has to be removed before the gateway
+// controller installs a chart version which manages it differently.
+func DeleteIngressClass(ctx context.Context, c client.Client, gatewayName string) (string, error) {
+	ingressClassName, err := IngressClassOf(ctx, c, gatewayName)
+	if err != nil {
+		return "", err
+	}
+	err = c.Delete(ctx, &v1.IngressClass{ObjectMeta: metav1.ObjectMeta{Name: ingressClassName}})
+	if err != nil {
+		return "", err
+	}
+	return ingressClassName, nil
+}
+
+// IngressClassOf returns the name of the IngressClass created by the gateway release.
+func IngressClassOf(ctx context.Context, c client.Client, gatewayName string) (string, error) {
+	ingressClassList := &v1.IngressClassList{}
+	err := c.List(ctx, ingressClassList, client.MatchingLabels{"app.kubernetes.io/instance": gatewayName})
+	if err != nil {
+		return "", err
+	}
+	if len(ingressClassList.Items) == 0 {
+		return "", fmt.Errorf("get gateway: %s ingressClass failed, please check it", gatewayName)
+	}
+	return ingressClassList.Items[0].Name, nil
+}
+
+// RestoreSpec sets the spec of the live gateway back to spec and waits for the release. As when
+// upgrading, the IngressClass of the current chart version has to go before the gateway controller
+// can install another one.
+func RestoreSpec(ctx context.Context, c client.Client, kubeconfig []byte, live *gatewayv2alpha2.Gateway, spec gatewayv2alpha2.GatewaySpec, waitOptions *options.WaitOptions) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	revision, err := CurrentRevision(kubeconfig, live.Namespace, live.Name)
+	if err != nil {
+		return err
+	}
+	// Once the IngressClass is gone the spec has to be updated too, a signal must not split them.
+	mutateCtx := context.WithoutCancel(ctx)
+	if live.Spec.AppVersion != spec.AppVersion {
+		ingressClassName, err := DeleteIngressClass(mutateCtx, c, live.Name)
+		if err != nil {
+			return err
+		}
+		klog.InfoS("Deleted ingress class", "namespace", live.Namespace, "gateway", live.Name, "ingressClass", ingressClassName)
+	}
+	restored := live.DeepCopy()
+	restored.Spec = spec
+	err = c.Update(mutateCtx, restored)
+	if err != nil {
+		return err
+	}
+	reconcile := ReconcileOf(restored, live.Generation, revision)
+	return WaitForRelease(ctx, c, kubeconfig, live.Namespace, live.Name, reconcile, waitOptions)
+}
+
+// Reconcile describes the release the gateway controller has to produce for an updated Gateway CR.
+type Reconcile struct {
+	// Revision of the release before the update, the controller has to deploy a newer one.
+	Revision int
+	// Generation of the updated CR, the gateway status has to have observed it.
+	Generation int64
+	AppVersion string
+}
+
+// ReconcileOf returns what to wait for after gw was updated from generation, nil if the update did
+// not change the spec and so the controller has nothing to reconcile.
+func ReconcileOf(gw *gatewayv2alpha2.Gateway, generation int64, revision int) *Reconcile {
+	if gw.Generation == generation {
+		return nil
+	}
+	return &Reconcile{Revision: revision, Generation: gw.Generation, AppVersion: gw.Spec.AppVersion}
+}
+
+// CurrentRevision returns the revision of the gateway helm release, 0 if there is none.
+func CurrentRevision(kubeconfig []byte, namespace, name string) (int, error) {
+	wrapper := helmwrapper.NewHelmWrapper(string(kubeconfig), namespace, name)
+	rel, err := wrapper.Status()
+	if err != nil {
+		if err.Error() == helmwrapper.StatusNotFoundFormat {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return rel.Version, nil
+}
+
+// WaitForRelease waits until the gateway controller reconciled the release and its resources are
+// ready. It first gives the controller SettleDelay to pick up the CR change, then waits up to
+// ReadyTimeout for reconcile, if not nil, and again for the resources. All waits end early when
+// ctx is done.
+func WaitForRelease(ctx context.Context, c client.Client, kubeconfig []byte, namespace, name string, reconcile *Reconcile, waitOptions *options.WaitOptions) error {
+	err := sleep(ctx, waitOptions.SettleDelay)
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	defer func() {
+		metrics.ObserveReadyWait(time.Since(start))
+	}()
+	wrapper := helmwrapper.NewHelmWrapper(string(kubeconfig), namespace, name)
+	if reconcile != nil {
+		err = waitForReconcile(ctx, c, wrapper, namespace, name, reconcile, waitOptions.ReadyTimeout)
+		if err != nil {
+			return err
+		}
+	}
+	ready, err := wrapper.IsReleaseReady(ctx, waitOptions.ReadyTimeout)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	if !ready {
+		return fmt.Errorf("gateway '%s/%s' is not ready, wait for release timeout", namespace, name)
+	}
+	return nil
+}
+
+// waitForReconcile polls until the release has a newer revision deployed with the expected app
+// version and the gateway status observed the updated generation, so readiness is checked against
+// the new release instead of the one the controller has not replaced yet.
+func waitForReconcile(ctx context.Context, c client.Client, wrapper helmwrapper.HelmWrapper, namespace, name string, reconcile *Reconcile, timeout time.Duration) error {
+	klog.InfoS("Wait for the gateway controller to reconcile the gateway", "namespace", namespace, "gateway", name,
+		"afterRevision", reconcile.Revision, "appVersion", reconcile.AppVersion, "generation", reconcile.Generation)
+	var pending string
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		gw := &gatewayv2alpha2.Gateway{}
+		err := c.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, gw)
+		if err != nil {
+			return false, err
+		}
+		observed, err := observedGeneration(gw)
+		if err != nil {
+			return false, err
+		}
+		// Controllers which do not report observedGeneration leave it 0, rely on the release then.
+		if observed != 0 && observed < reconcile.Generation {
+			pending = fmt.Sprintf("gateway status observed generation %d", observed)
+			return false, nil
+		}
+
+		rel, err := wrapper.Status()
+		if err != nil {
+			pending = err.Error()
+			return false, nil
+		}
+		if rel.Version <= reconcile.Revision {
+			pending = fmt.Sprintf("release is still at revision %d", rel.Version)
+			return false, nil
+		}
+		if !MatchesAppVersion(rel, reconcile.AppVersion) {
+			pending = fmt.Sprintf("release revision %d has app version %s", rel.Version, AppVersionOf(rel))
+			return false, nil
+		}
+		switch rel.Info.Status {
+		case helmrelease.StatusDeployed:
+			return true, nil
+		case helmrelease.StatusFailed:
+			return false, fmt.Errorf("release revision %d failed: %s", rel.Version, rel.Info.Description)
+		default:
+			pending = fmt.Sprintf("release revision %d is %s", rel.Version, rel.Info.Status)
+			return false, nil
+		}
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if wait.Interrupted(err) {
+		return fmt.Errorf("gateway '%s/%s' was not reconciled within %s, %s", namespace, name, timeout, pending)
+	}
+	return err
+}
+
+// observedGeneration returns the newest generation observed by the conditions of the gateway status.
+func observedGeneration(gw *gatewayv2alpha2.Gateway) (int64, error) {
+	status, err := gw.GetStatus()
+	if err != nil {
+		return 0, err
+	}
+	var observed int64
+	for _, condition := range status.Conditions {
+		if condition.Type == gatewayv2alpha2.ConditionTypeDeployd && condition.ObservedGeneration > observed {
+			observed = condition.ObservedGeneration
+		}
+	}
+	return observed, nil
+}
+
+// AppVersionOf returns the Gateway app version of a release, the gateway controller names
+// app versions after the chart, e.g. kubesphere-nginx-ingress-4.12.1.
+func AppVersionOf(rel *helmrelease.Release) string {
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s-%s", rel.Chart.Metadata.Name, rel.Chart.Metadata.Version)
+}
+
+// MatchesAppVersion reports whether the release was installed for the Gateway app version. Besides
+// the <chart>-<version> naming of AppVersionOf it accepts the bare chart version and the appVersion
+// of the chart, and a release without chart metadata, so a controller naming app versions
+// differently is not taken for drift.
+func MatchesAppVersion(rel *helmrelease.Release, appVersion string) bool {
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return true
+	}
+	metadata := rel.Chart.Metadata
+	return appVersion == AppVersionOf(rel) || appVersion == metadata.Version ||
+		(metadata.AppVersion != "" && appVersion == metadata.AppVersion)
+}
@@ -0,0 +1,188 @@
+package upgrade
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+)
+
+const (
+	ReportFormatJSON     = "json"
+	ReportFormatMarkdown = "markdown"
+)
+
+// Report is the machine readable summary of a run, written with --report-file.
+type Report struct {
+	RunID         string  `json:"runID,omitempty"`
+	TargetVersion string  `json:"targetVersion"`
+	StartTime     string  `json:"startTime"`
+	EndTime       string  `json:"endTime"`
+	Duration      float64 `json:"durationSeconds"`
+	// Backup is the backup file of the run, empty if backups were disabled.
+	Backup string `json:"backup,omitempty"`
+	// Error is the error the run ended with, empty if it succeeded.
+	Error    string             `json:"error,omitempty"`
+	Summary  map[Outcome]int    `json:"summary"`
+	Checks   []ReportCheck      `json:"preflightChecks,omitempty"`
+	Gateways []ReportGatewayRun `json:"gateways"`
+}
+
+type ReportCheck struct {
+	Name    string      `json:"name"`
+	Target  string      `json:"target,omitempty"`
+	Status  CheckStatus `json:"status"`
+	Message string      `json:"message"`
+}
+
+type ReportGatewayRun struct {
+	Namespace    string  `json:"namespace"`
+	Name         string  `json:"name"`
+	Outcome      Outcome `json:"outcome"`
+	Reason       string  `json:"reason,omitempty"`
+	FromVersion  string  `json:"fromVersion,omitempty"`
+	ToVersion    string  `json:"toVersion,omitempty"`
+	IngressClass string  `json:"deletedIngressClass,omitempty"`
+	StartTime    string  `json:"startTime,omitempty"`
+	Duration     float64 `json:"durationSeconds"`
+}
+
+// NewReport builds the report of a run which started at start. Gateways without a result, e.g.
+// because the preflight checks failed, are reported as not started.
+func NewReport(start time.Time, targetVersion string, gateways []gatewayv2alpha2.Gateway, checks []CheckResult, results []GatewayResult, runErr error) *Report {
+	end := time.Now()
+	report := &Report{
+		TargetVersion: targetVersion,
+		StartTime:     start.Format(time.RFC3339),
+		EndTime:       end.Format(time.RFC3339),
+		Duration:      end.Sub(start).Round(time.Millisecond).Seconds(),
+		Summary:       map[Outcome]int{},
+		Gateways:      []ReportGatewayRun{},
+	}
+	if runErr != nil {
+		report.Error = runErr.Error()
+	}
+	for _, check := range checks {
+		report.Checks = append(report.Checks, ReportCheck{Name: check.Name, Target: check.Target, Status: check.Status, Message: check.Message})
+	}
+	if results == nil {
+		for _, gw := range gateways {
+			results = append(results, GatewayResult{Namespace: gw.Namespace, Name: gw.Name, FromVersion: gw.Spec.AppVersion, Outcome: OutcomeNotStarted})
+		}
+	}
+	for _, result := range results {
+		report.Summary[result.Outcome]++
+		gatewayRun := ReportGatewayRun{
+			Namespace:    result.Namespace,
+			Name:         result.Name,
+			Outcome:      result.Outcome,
+			Reason:       result.Reason,
+			FromVersion:  result.FromVersion,
+			ToVersion:    result.ToVersion,
+			IngressClass: result.IngressClass,
+			Duration:     result.Duration.Round(time.Millisecond).Seconds(),
+		}
+		if !result.Start.IsZero() {
+			gatewayRun.StartTime = result.Start.Format(time.RFC3339)
+		}
+		report.Gateways = append(report.Gateways, gatewayRun)
+	}
+	return report
+}
+
+// ValidateReportFormat checks --report-format, so a typo fails the run before anything is changed.
+func ValidateReportFormat(format string) error {
+	switch format {
+	case ReportFormatJSON, ReportFormatMarkdown, "":
+		return nil
+	default:
+		return fmt.Errorf("unknown report format %q, use %s or %s", format, ReportFormatJSON, ReportFormatMarkdown)
+	}
+}
+
+// WriteFile writes the report to path in format.
+func (rp *Report) WriteFile(path, format string) error {
+	if err := ValidateReportFormat(format); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if format == ReportFormatMarkdown {
+		err = rp.WriteMarkdown(file)
+	} else {
+		err = rp.WriteJSON(file)
+	}
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func (rp *Report) WriteJSON(out io.Writer) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rp)
+}
+
+func (rp *Report) WriteMarkdown(out io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# Gateway upgrade report\n\n")
+	if rp.RunID != "" {
+		fmt.Fprintf(&b, "- Run: `%s`\n", rp.RunID)
+	}
+	fmt.Fprintf(&b, "- Target version: `%s`\n", rp.TargetVersion)
+	fmt.Fprintf(&b, "- Started: %s, finished: %s (%.0fs)\n", rp.StartTime, rp.EndTime, rp.Duration)
+	if rp.Backup != "" {
+		fmt.Fprintf(&b, "- Backup: `%s`\n", rp.Backup)
+	}
+	result := "succeeded"
+	if rp.Error != "" {
+		result = "failed: " + markdownCell(rp.Error)
+	}
+	fmt.Fprintf(&b, "- Result: %s\n", result)
+	for _, outcome := range []Outcome{OutcomeUpgraded, OutcomeSkipped, OutcomeFailed, OutcomeNotStarted} {
+		fmt.Fprintf(&b, "- %s: %d\n", outcome, rp.Summary[outcome])
+	}
+
+	b.WriteString("\n## Gateways\n\n")
+	b.WriteString("| Gateway | Outcome | From | To | Deleted IngressClass | Started | Duration | Reason |\n")
+	b.WriteString("|---|---|---|---|---|---|---|---|\n")
+	for _, gw := range rp.Gateways {
+		fmt.Fprintf(&b, "| %s/%s | %s | %s | %s | %s | %s | %.0fs | %s |\n", gw.Namespace, gw.Name, gw.Outcome,
+			markdownCell(gw.FromVersion), markdownCell(gw.ToVersion), markdownCell(gw.IngressClass),
+			markdownCell(gw.StartTime), gw.Duration, markdownCell(gw.Reason))
+	}
+
+	var problems []ReportCheck
+	for _, check := range rp.Checks {
+		if check.Status != CheckPass {
+			problems = append(problems, check)
+		}
+	}
+	if len(problems) > 0 {
+		b.WriteString("\n## Preflight warnings and failures\n\n")
+		b.WriteString("| Check | Target | Status | Message |\n")
+		b.WriteString("|---|---|---|---|\n")
+		for _, check := range problems {
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", check.Name, markdownCell(check.Target), check.Status, markdownCell(check.Message))
+		}
+	}
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+// markdownCell escapes s for a table cell, "-" stands for empty.
+func markdownCell(s string) string {
+	if s == "" {
+		return "-"
+	}
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
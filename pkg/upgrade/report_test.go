@@ -0,0 +1,122 @@
+package upgrade
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+)
+
+func testReport() *Report {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	checks := []CheckResult{
+		{Name: "crd", Status: CheckPass, Message: "installed"},
+		{Name: "release", Target: "a/gw2", Status: CheckWarn, Message: "values differ | drift"},
+	}
+	results := []GatewayResult{
+		{Namespace: "a", Name: "gw1", Outcome: OutcomeUpgraded, FromVersion: "old", ToVersion: TargetVersion, IngressClass: "gw1", Start: start, Duration: 90 * time.Second},
+		{Namespace: "a", Name: "gw2", Outcome: OutcomeFailed, FromVersion: "old", Reason: "release failed\nsee events"},
+	}
+	report := NewReport(start, TargetVersion, nil, checks, results, errors.New("failed to upgrade a/gw2"))
+	report.RunID = "20240101000000-abcde"
+	report.EndTime, report.Duration = "2024-01-01T00:02:00Z", 120
+	return report
+}
+
+func TestNewReportNotStarted(t *testing.T) {
+	gw := testGateway("a", "gw1", "")
+	gw.Spec.AppVersion = "old"
+	report := NewReport(time.Now(), TargetVersion, []gatewayv2alpha2.Gateway{gw}, nil, nil, nil)
+	want := []ReportGatewayRun{{Namespace: "a", Name: "gw1", Outcome: OutcomeNotStarted, FromVersion: "old"}}
+	if !reflect.DeepEqual(report.Gateways, want) || report.Summary[OutcomeNotStarted] != 1 || report.Error != "" {
+		t.Errorf("NewReport() = %+v, want gw1 not started", report)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var out bytes.Buffer
+	if err := testReport().WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var got Report
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("WriteJSON() wrote invalid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(&got, testReport()) {
+		t.Errorf("WriteJSON() roundtrip = %+v, want %+v", got, testReport())
+	}
+	for _, key := range []string{`"targetVersion"`, `"preflightChecks"`, `"deletedIngressClass": "gw1"`, `"durationSeconds": 90`} {
+		if !strings.Contains(out.String(), key) {
+			t.Errorf("WriteJSON() output lacks %s:\n%s", key, out.String())
+		}
+	}
+}
+
+func TestWriteMarkdown(t *testing.T) {
+	var out bytes.Buffer
+	if err := testReport().WriteMarkdown(&out); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "run", want: "- Run: `20240101000000-abcde`\n"},
+		{name: "target version", want: "- Target version: `" + TargetVersion + "`\n"},
+		{name: "result", want: "- Result: failed: failed to upgrade a/gw2\n"},
+		{name: "summary", want: "- upgraded: 1\n- skipped: 0\n- failed: 1\n- not-started: 0\n"},
+		{name: "upgraded gateway", want: "| a/gw1 | upgraded | old | " + TargetVersion + " | gw1 | 2024-01-01T00:00:00Z | 90s | - |\n"},
+		{name: "failed gateway, reason on one line", want: "| a/gw2 | failed | old | - | - | - | 0s | release failed see events |\n"},
+		{name: "escaped check message", want: "| release | a/gw2 | WARN | values differ \\| drift |\n"},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(out.String(), tt.want) {
+			t.Errorf("WriteMarkdown() lacks the %s %q:\n%s", tt.name, tt.want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "| crd |") {
+		t.Errorf("WriteMarkdown() lists a passed check:\n%s", out.String())
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{format: "", want: `"targetVersion"`},
+		{format: ReportFormatJSON, want: `"targetVersion"`},
+		{format: ReportFormatMarkdown, want: "# Gateway upgrade report"},
+		{format: "yaml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "report")
+			err := testReport().WriteFile(path, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WriteFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (ValidateReportFormat(tt.format) != nil) != tt.wantErr {
+				t.Errorf("ValidateReportFormat(%q) disagrees with WriteFile()", tt.format)
+			}
+			data, err := os.ReadFile(path)
+			if tt.wantErr {
+				if !os.IsNotExist(err) {
+					t.Errorf("WriteFile() with an unknown format left a file behind: %v", err)
+				}
+				return
+			}
+			if err != nil || !strings.Contains(string(data), tt.want) {
+				t.Errorf("WriteFile() wrote %q, %v, want it to contain %q", data, err, tt.want)
+			}
+		})
+	}
+}
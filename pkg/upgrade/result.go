@@ -0,0 +1,65 @@
+package upgrade
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+type Outcome string
+
+const (
+	OutcomeUpgraded   Outcome = "upgraded"
+	OutcomeSkipped    Outcome = "skipped"
+	OutcomeFailed     Outcome = "failed"
+	OutcomeNotStarted Outcome = "not-started"
+)
+
+// GatewayResult is the outcome of one gateway in a run.
+type GatewayResult struct {
+	Namespace string
+	Name      string
+	Outcome   Outcome
+	// Reason is the skip reason or the error of a failed gateway
+	Reason string
+	// FromVersion is the app version before the run, ToVersion the one after a successful upgrade
+	FromVersion string
+	ToVersion   string
+	// IngressClass is the deleted IngressClass of the previous chart version
+	IngressClass string
+	// Start is when the gateway was started, zero if it was not
+	Start    time.Time
+	Duration time.Duration
+	// EarlierAttempt is set if an earlier attempt of the resumed run completed the upgrade
+	EarlierAttempt bool
+	// UpToDate is set if the gateway was skipped because it runs the target version already
+	UpToDate bool
+}
+
+func (g GatewayResult) FullName() string {
+	return fmt.Sprintf("%s/%s", g.Namespace, g.Name)
+}
+
+func CountOutcome(results []GatewayResult, outcome Outcome) int {
+	count := 0
+	for _, result := range results {
+		if result.Outcome == outcome {
+			count++
+		}
+	}
+	return count
+}
+
+func PrintResults(out io.Writer, results []GatewayResult) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "GATEWAY\tOUTCOME\tREASON")
+	for _, result := range results {
+		reason := result.Reason
+		if reason == "" {
+			reason = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", result.FullName(), result.Outcome, reason)
+	}
+	w.Flush()
+}
@@ -5,33 +5,39 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"dario.cat/mergo"
 
 	corev1 "k8s.io/api/core/v1"
-	v1 "k8s.io/api/networking/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/yaml"
 
 	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
 	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
-	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
-	"github.com/zhou1203/GatewayUpgradeTool/pkg/simple/helmwrapper"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/backup"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/kubeclient"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/lock"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/logging"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/metrics"
 	"github.com/zhou1203/GatewayUpgradeTool/pkg/template"
 )
 
 const (
-	TargetVersion = "kubesphere-nginx-ingress-4.12.1"
+	// TargetVersion is the default of RunOptions.TargetVersion.
+	TargetVersion = options.DefaultTargetVersion
 
 	ExtensionNamespace   = "extension-gateway"
 	GatewayConfigMapName = "gateway-agent-backend-config"
@@ -41,7 +47,13 @@ type Runner struct {
 	Client       client.Client
 	GatewayNames []*gatewayv2alpha2.GatewayReference
 	Kubeconfig   []byte
+	RestConfig   *rest.Config
 	RunOptions   options.RunOptions
+	// Recorder emits Events on the gateways, nil disables them.
+	Recorder record.EventRecorder
+	// checkpoint records the progress of the run, nil until the run started.
+	checkpoint  *Checkpoint
+	broadcaster *EventBroadcaster
 }
 
 type BackupOptions struct {
@@ -50,16 +62,29 @@ type BackupOptions struct {
 }
 
 func NewRunner(options *options.RunOptions) (*Runner, error) {
+	if err := ValidateReportFormat(options.ReportFormat); err != nil {
+		return nil, err
+	}
 	r := &Runner{}
-	kubeClient, err := buildClient(options.KubeConfigPath)
+	restConfig, err := kubeclient.RESTConfig(options.KubeConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubeclient.NewForConfig(restConfig)
 	if err != nil {
 		return nil, err
 	}
+	r.RestConfig = restConfig
 	r.Client = kubeClient
+	r.broadcaster, err = NewEventBroadcaster(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	r.Recorder = r.broadcaster.NewRecorder()
 	r.RunOptions = *options
 	if GetAll(options.GatewayNames) {
 		// TODO get all gateways
-		klog.Warning("upgrade all gateways is not supported yet")
+		logging.WarningS("Upgrade all gateways is not supported yet")
 	}
 	if r.RunOptions.KubeConfigPath != "" {
 		file, err := os.ReadFile(options.KubeConfigPath)
@@ -68,7 +93,7 @@ func NewRunner(options *options.RunOptions) (*Runner, error) {
 		}
 		r.Kubeconfig = file
 	}
-	r.GatewayNames = newGatewayReferences(options.GatewayNames)
+	r.GatewayNames = NewGatewayReferences(options.GatewayNames)
 	return r, nil
 }
 
@@ -85,7 +110,7 @@ func GetAll(options string) bool {
 	return options == "*"
 }
 
-func newGatewayReferences(gatewayNames string) []*gatewayv2alpha2.GatewayReference {
+func NewGatewayReferences(gatewayNames string) []*gatewayv2alpha2.GatewayReference {
 	gatewayRefs := make([]*gatewayv2alpha2.GatewayReference, 0)
 	split := strings.Split(gatewayNames, ",")
 	for _, fullName := range split {
@@ -96,12 +121,58 @@ func newGatewayReferences(gatewayNames string) []*gatewayv2alpha2.GatewayReferen
 	return gatewayRefs
 }
 
-func (r *Runner) Run(ctx context.Context) error {
-	if len(r.GatewayNames) == 0 {
-		klog.Infof("No gateway need to upgrade")
+func (r *Runner) Run(ctx context.Context) (err error) {
+	if r.broadcaster != nil {
+		// Sends the Events still queued.
+		defer r.broadcaster.Shutdown(EventFlushTimeout)
+	}
+	if len(r.GatewayNames) == 0 && r.RunOptions.Resume == "" {
+		klog.InfoS("No gateway need to upgrade", r.logKeys("", "")...)
 		return nil
 	}
-	gateways, err := r.getGateways(ctx)
+	var (
+		gateways []gatewayv2alpha2.Gateway
+		checks   []CheckResult
+		results  []GatewayResult
+	)
+	start := time.Now()
+	if r.RunOptions.Metrics.BindAddress != "" {
+		metricsCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancel()
+		metrics.Serve(metricsCtx, r.RunOptions.Metrics.BindAddress)
+	}
+	defer func() {
+		r.exportMetrics(start, results, err)
+	}()
+	if r.RunOptions.ReportFile != "" {
+		defer func() {
+			reportErr := r.writeReport(start, gateways, checks, results, err)
+			if reportErr != nil && err == nil {
+				err = reportErr
+			}
+		}()
+	}
+	lease := lock.New(r.Client, ExtensionNamespace)
+	ctx, err = lease.Acquire(ctx, r.RunOptions.ForceUnlock)
+	if err != nil {
+		return err
+	}
+	defer lease.Release()
+
+	if r.RunOptions.Resume != "" {
+		r.checkpoint, err = LoadCheckpoint(ctx, r.Client, r.RunOptions.Resume)
+		if err != nil {
+			return err
+		}
+		if r.checkpoint.Finished() {
+			return fmt.Errorf("run %s already finished, nothing to resume", r.checkpoint.ID())
+		}
+		// The gateways of the run take precedence over --gateways.
+		r.GatewayNames = r.checkpoint.GatewayReferences()
+		klog.InfoS("Resume run", r.logKeys("", "")...)
+	}
+
+	gateways, err = r.getGateways(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get gateways: %w", err)
 	}
@@ -112,18 +183,100 @@ func (r *Runner) Run(ctx context.Context) error {
 		gatewayFullNames = append(gatewayFullNames, fullName)
 	}
 
-	if r.RunOptions.Backup.Enabled {
-		klog.Info("Start to backup gateways. gateways: ", gatewayFullNames)
-		err := r.CreateBackupFile(gateways)
+	klog.InfoS("Start to run preflight checks", r.logKeys("", "", "gateways", gatewayFullNames)...)
+	metrics.SetGateways(len(gateways))
+	checks = r.Preflight(ctx, gateways)
+	PrintCheckResults(os.Stdout, checks)
+	if failed := FailedChecks(checks); len(failed) > 0 {
+		return fmt.Errorf("%d preflight checks failed, nothing has been changed", len(failed))
+	}
+
+	switch {
+	case r.checkpoint == nil:
+		// The backup is taken first, so every run record of a backed up run names its backup.
+		var backupPath string
+		if r.RunOptions.Backup.Enabled {
+			backupPath, err = r.backupGateways(gateways)
+			if err != nil {
+				return err
+			}
+		}
+		r.checkpoint, err = CreateCheckpoint(ctx, r.Client, gateways, backupPath)
+		if err != nil {
+			return err
+		}
+		klog.InfoS("Start run, continue it with --resume if it is interrupted", r.logKeys("", "")...)
+	case r.checkpoint.Backup() != "":
+		// A new backup would already hold the upgraded gateways.
+		klog.InfoS("Skip backup, the gateways of the run were backed up already", r.logKeys("", "", "backup", r.checkpoint.Backup())...)
+	case r.RunOptions.Backup.Enabled:
+		// The earlier attempt ran without a backup, only the gateways it did not change yet can be
+		// backed up as they were before the run.
+		var unchanged []gatewayv2alpha2.Gateway
+		for i := range gateways {
+			if !r.record(&gateways[i]).Phase.Reached(PhaseIngressClassDeleting) {
+				unchanged = append(unchanged, gateways[i])
+			}
+		}
+		if len(unchanged) < len(gateways) {
+			logging.WarningS("Gateways changed by an earlier attempt of the run are not backed up", r.logKeys("", "", "changed", len(gateways)-len(unchanged))...)
+		}
+		if len(unchanged) > 0 {
+			backupPath, err := r.backupGateways(unchanged)
+			if err != nil {
+				return err
+			}
+			err = r.checkpoint.SetBackup(ctx, backupPath)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	klog.InfoS("Start to upgrade gateways", r.logKeys("", "", "gateways", gatewayFullNames)...)
+	results, err = r.UpgradeInWaves(ctx, gateways)
+	PrintResults(os.Stdout, results)
+	r.RecordHistory(ctx, results)
+	if err != nil {
+		return fmt.Errorf("failed to upgrade gateways, continue with --resume %s: %w", r.checkpoint.ID(), err)
+	}
+	return r.checkpoint.SetFinished(ctx)
+}
+
+// exportMetrics records the outcome of the run and writes or pushes the metrics as configured.
+func (r *Runner) exportMetrics(start time.Time, results []GatewayResult, runErr error) {
+	for _, result := range results {
+		if result.Start.IsZero() {
+			metrics.GatewayNotStarted(string(result.Outcome))
+		}
+	}
+	metrics.RunFinished(time.Since(start), runErr)
+	metricsOptions := r.RunOptions.Metrics
+	if metricsOptions.TextfilePath != "" {
+		err := metrics.WriteTextfile(metricsOptions.TextfilePath)
 		if err != nil {
-			return fmt.Errorf("failed to backup gateways: %w", err)
+			klog.ErrorS(err, "Failed to write metrics", r.logKeys("", "", "path", metricsOptions.TextfilePath)...)
 		}
 	}
-	klog.Info("Start to upgrade gateways. gateways: ", gatewayFullNames)
-	err = r.UpgradeGateways(ctx, gateways)
+	if metricsOptions.PushgatewayURL != "" {
+		err := metrics.Push(metricsOptions.PushgatewayURL)
+		if err != nil {
+			klog.ErrorS(err, "Failed to push metrics", r.logKeys("", "", "url", metricsOptions.PushgatewayURL)...)
+		}
+	}
+}
+
+// writeReport writes the report of the run to RunOptions.ReportFile.
+func (r *Runner) writeReport(start time.Time, gateways []gatewayv2alpha2.Gateway, checks []CheckResult, results []GatewayResult, runErr error) error {
+	report := NewReport(start, r.RunOptions.TargetVersion, gateways, checks, results, runErr)
+	if r.checkpoint != nil {
+		report.RunID, report.Backup = r.checkpoint.ID(), r.checkpoint.Backup()
+	}
+	err := report.WriteFile(r.RunOptions.ReportFile, r.RunOptions.ReportFormat)
 	if err != nil {
-		return fmt.Errorf("failed to upgrade gateways: %w", err)
+		klog.ErrorS(err, "Failed to write report", r.logKeys("", "", "path", r.RunOptions.ReportFile)...)
+		return fmt.Errorf("failed to write report: %w", err)
 	}
+	klog.InfoS("Report written", r.logKeys("", "", "path", r.RunOptions.ReportFile)...)
 	return nil
 }
 
@@ -143,124 +296,374 @@ func (r *Runner) getGateways(ctx context.Context) ([]gatewayv2alpha2.Gateway, er
 }
 
 func (r *Runner) isRequiredVersion(appVersion string) bool {
-	return (r.RunOptions.SpecificAppVersion != "" && appVersion == r.RunOptions.SpecificAppVersion) || appVersion != TargetVersion
+	return (r.RunOptions.SpecificAppVersion != "" && appVersion == r.RunOptions.SpecificAppVersion) || appVersion != r.RunOptions.TargetVersion
 }
 
-func (r *Runner) UpgradeGateways(ctx context.Context, gateways []gatewayv2alpha2.Gateway) error {
-	for _, gw := range gateways {
-		klog.Infof("Begin to Upgrade gateway %s/%s.", gw.Namespace, gw.Name)
-		if !r.isRequiredVersion(gw.Spec.AppVersion) {
-			klog.Warningf("Gateway %s app version does not match, will skip it", gw.Name)
-			continue
-		}
-		if !gw.IsDeployed() {
-			klog.Warningf("Gateway %s is not deployed, will skip it", gw.Name)
-			continue
-		}
-		if !gw.IsDeploymentReady() {
-			klog.Warningf("Gateway %s is not ready, will skip it", gw.Name)
-			continue
+// UpgradeGateways upgrades the gateways with up to RunOptions.Concurrency workers and returns the
+// outcome of every gateway, in the order of gateways. Once a gateway fails no further gateway is
+// started unless RunOptions.ContinueOnError is set, the ones in flight are finished either way.
+// The returned error aggregates all failures.
+func (r *Runner) UpgradeGateways(ctx context.Context, gateways []gatewayv2alpha2.Gateway) ([]GatewayResult, error) {
+	concurrency := r.RunOptions.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]GatewayResult, len(gateways))
+	for i, gw := range gateways {
+		results[i] = GatewayResult{Namespace: gw.Namespace, Name: gw.Name, FromVersion: gw.Spec.AppVersion, Outcome: OutcomeNotStarted}
+	}
+
+	var (
+		mu      sync.Mutex
+		errs    []error
+		aborted bool
+		wg      sync.WaitGroup
+	)
+	queue := make(chan int)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range queue {
+				gw := gateways[index]
+				mu.Lock()
+				skip := aborted
+				mu.Unlock()
+				if skip {
+					logging.WarningS("Gateway is not upgraded because an earlier gateway failed", r.logKeys(gw.Namespace, gw.Name)...)
+					results[index].Reason = "an earlier gateway failed"
+					continue
+				}
+				if ctx.Err() != nil {
+					logging.WarningS("Gateway is not upgraded because the upgrade was interrupted", r.logKeys(gw.Namespace, gw.Name)...)
+					results[index].Reason = "interrupted"
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("upgrade interrupted before gateway %s/%s: %w", gw.Namespace, gw.Name, ctx.Err()))
+					mu.Unlock()
+					continue
+				}
+				start := time.Now()
+				result := r.upgradeGateway(ctx, gw)
+				result.Start, result.Duration = start, time.Since(start)
+				metrics.GatewayFinished(string(result.Outcome), result.Duration)
+				results[index] = result
+				if result.Outcome == OutcomeFailed {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("failed to upgrade gateway %s: %s", result.FullName(), result.Reason))
+					aborted = !r.RunOptions.ContinueOnError
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for index, gw := range gateways {
+		// select picks at random among ready cases, so check ctx first to not dispatch after it is done.
+		if ctx.Err() == nil {
+			select {
+			case <-ctx.Done():
+			case queue <- index:
+				continue
+			}
 		}
-		err := r.upgrade(ctx, gw)
-		if err != nil {
-			return fmt.Errorf("failed to upgrade gateway %s: %v", gw.Name, err)
+		mu.Lock()
+		errs = append(errs, fmt.Errorf("upgrade interrupted before gateway %s/%s: %w", gw.Namespace, gw.Name, ctx.Err()))
+		mu.Unlock()
+		break
+	}
+	close(queue)
+	wg.Wait()
+	return results, utilerrors.NewAggregate(errs)
+}
+
+func (r *Runner) upgradeGateway(ctx context.Context, gw gatewayv2alpha2.Gateway) GatewayResult {
+	record := r.record(&gw)
+	result := GatewayResult{Namespace: gw.Namespace, Name: gw.Name, FromVersion: fromVersion(&gw, record)}
+	skip := func(reason string) GatewayResult {
+		logging.WarningS("Skip gateway", r.logKeys(gw.Namespace, gw.Name, "reason", reason)...)
+		r.event(&gw, corev1.EventTypeNormal, EventReasonUpgradeSkipped, "Gateway %s, not upgraded to %s", reason, r.RunOptions.TargetVersion)
+		result.Outcome, result.Reason = OutcomeSkipped, reason
+		return result
+	}
+
+	klog.InfoS("Begin to upgrade gateway", r.logKeys(gw.Namespace, gw.Name, "appVersion", gw.Spec.AppVersion)...)
+	if record.Phase.Reached(PhaseReady) {
+		klog.InfoS("Gateway was upgraded by an earlier attempt of the run", r.logKeys(gw.Namespace, gw.Name, "phase", record.Phase)...)
+		result.Outcome, result.Reason = OutcomeUpgraded, "upgraded by an earlier attempt"
+		result.EarlierAttempt = true
+		result.ToVersion, result.IngressClass = gw.Spec.AppVersion, record.IngressClass
+		return result
+	}
+	if record.Phase.Reached(PhaseIngressClassDeleting) {
+		// The earlier attempt already changed the gateway, the checks below no longer apply.
+		klog.InfoS("Continue gateway from the phase of an earlier attempt", r.logKeys(gw.Namespace, gw.Name, "phase", record.Phase)...)
+		return r.finishUpgrade(ctx, gw, record)
+	}
+	if gw.Spec.AppVersion == r.RunOptions.TargetVersion && !r.isRequiredVersion(gw.Spec.AppVersion) {
+		result.UpToDate = true
+		return skip("is already at the target version")
+	}
+	if !r.isRequiredVersion(gw.Spec.AppVersion) {
+		return skip(fmt.Sprintf("app version %s does not match", gw.Spec.AppVersion))
+	}
+	if !gw.IsDeployed() {
+		return skip("is not deployed")
+	}
+	if !gw.IsDeploymentReady() {
+		return skip("is not ready")
+	}
+	drift, err := DetectDrift(r.Kubeconfig, &gw)
+	if err != nil {
+		result.Outcome, result.Reason = OutcomeFailed, fmt.Sprintf("failed to detect drift: %v", err)
+		r.event(&gw, corev1.EventTypeWarning, EventReasonUpgradeFailed, "Upgrade failed: %s", result.Reason)
+		return result
+	}
+	if len(drift) > 0 {
+		if !r.RunOptions.IgnoreDrift {
+			return skip(fmt.Sprintf("has drifted from its helm release: %s", strings.Join(drift, "; ")))
 		}
-		klog.Infof("Upgrade gateway %s/%s successfully.", gw.Namespace, gw.Name)
+		logging.WarningS("Gateway has drifted from its helm release, upgrade it anyway", r.logKeys(gw.Namespace, gw.Name, "drift", drift)...)
 	}
-	return nil
+	return r.finishUpgrade(ctx, gw, record)
 }
 
-func (r *Runner) upgrade(ctx context.Context, old gatewayv2alpha2.Gateway) error {
-	service := &corev1.Service{}
-	err := r.Client.Get(ctx, types.NamespacedName{Namespace: old.Namespace, Name: old.Name}, service)
+// finishUpgrade upgrades the gateway from the phase in record on and runs the smoke tests.
+func (r *Runner) finishUpgrade(ctx context.Context, gw gatewayv2alpha2.Gateway, record GatewayRecord) GatewayResult {
+	result := GatewayResult{Namespace: gw.Namespace, Name: gw.Name, FromVersion: fromVersion(&gw, record)}
+	resumed := record.Phase.Reached(PhaseCRUpdated)
+	if record.Phase.Reached(PhaseIngressClassDeleting) {
+		r.event(&gw, corev1.EventTypeNormal, EventReasonUpgradeStarted, "Continue upgrade to %s from phase %s", r.RunOptions.TargetVersion, record.Phase)
+	} else {
+		r.event(&gw, corev1.EventTypeNormal, EventReasonUpgradeStarted, "Upgrade from %s to %s started", gw.Spec.AppVersion, r.RunOptions.TargetVersion)
+	}
+	err := r.upgrade(ctx, gw, &record)
+	result.IngressClass = record.IngressClass
 	if err != nil {
-		return err
+		klog.ErrorS(err, "Failed to upgrade gateway", r.logKeys(gw.Namespace, gw.Name, "phase", record.Phase)...)
+		result.Outcome, result.Reason = OutcomeFailed, err.Error()
+		r.event(&gw, corev1.EventTypeWarning, EventReasonUpgradeFailed, "Upgrade failed: %s", result.Reason)
+		return result
 	}
-	if service.Spec.Type == corev1.ServiceTypeNodePort {
-		if old.Annotations == nil {
-			old.Annotations = map[string]string{}
-		}
-		for _, port := range service.Spec.Ports {
-			if port.Name == "http" {
-				old.Annotations[template.AnnotationsNodePortHttp] = strconv.Itoa(int(port.NodePort))
-			}
-			if port.Name == "https" {
-				old.Annotations[template.AnnotationsNodePortHttps] = strconv.Itoa(int(port.NodePort))
+	if SmokeTestEnabled(r.RunOptions.SmokeTest) {
+		err = r.runSmokeTests(ctx, &gw)
+		if err != nil {
+			klog.ErrorS(err, "Smoke tests failed", r.logKeys(gw.Namespace, gw.Name, "phase", record.Phase)...)
+			result.Outcome, result.Reason = OutcomeFailed, fmt.Sprintf("smoke tests failed: %v", err)
+			switch {
+			case !r.RunOptions.SmokeTest.Rollback:
+			case resumed:
+				// gw is the spec written by the earlier attempt, the one before is only in the backup.
+				result.Reason += ", the CR was updated by an earlier attempt, restore it with rollback --from-backup"
+			default:
+				result.Reason += ", " + r.rollbackSpec(ctx, &gw)
 			}
+			r.event(&gw, corev1.EventTypeWarning, EventReasonUpgradeFailed, "Upgrade failed: %s", result.Reason)
+			return result
 		}
 	}
+	r.event(&gw, corev1.EventTypeNormal, EventReasonReleaseReady, "Release of %s is ready", r.RunOptions.TargetVersion)
+	record.Phase = PhaseReady
+	err = r.saveRecord(ctx, &gw, record)
+	if err != nil {
+		result.Outcome, result.Reason = OutcomeFailed, err.Error()
+		return result
+	}
+	klog.InfoS("Upgraded gateway", r.logKeys(gw.Namespace, gw.Name, "phase", record.Phase, "appVersion", r.RunOptions.TargetVersion)...)
+	result.Outcome, result.ToVersion = OutcomeUpgraded, r.RunOptions.TargetVersion
+	return result
+}
 
-	jsonBytes, err := template.HandleTemplate(&old)
+// rollbackSpec restores the spec the gateway had before the upgrade and describes the outcome.
+func (r *Runner) rollbackSpec(ctx context.Context, old *gatewayv2alpha2.Gateway) string {
+	live := &gatewayv2alpha2.Gateway{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: old.Namespace, Name: old.Name}, live)
+	if err == nil {
+		err = RestoreSpec(ctx, r.Client, r.Kubeconfig, live, old.Spec, r.RunOptions.Wait)
+	}
 	if err != nil {
+		klog.ErrorS(err, "Failed to rollback gateway", r.logKeys(old.Namespace, old.Name)...)
+		return fmt.Sprintf("rollback failed: %v", err)
+	}
+	klog.InfoS("Rolled back gateway", r.logKeys(old.Namespace, old.Name, "appVersion", old.Spec.AppVersion)...)
+	// A resumed run has to upgrade the gateway from scratch again.
+	err = r.saveRecord(ctx, old, GatewayRecord{FromVersion: old.Spec.AppVersion})
+	if err != nil {
+		return fmt.Sprintf("rolled back to %s, %v", old.Spec.AppVersion, err)
+	}
+	return "rolled back to " + old.Spec.AppVersion
+}
+
+// upgrade continues the upgrade of the gateway after the last phase completed according to record,
+// updating record and storing it in the checkpoint after every completed phase.
+func (r *Runner) upgrade(ctx context.Context, old gatewayv2alpha2.Gateway, record *GatewayRecord) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	// Once the IngressClass is gone the CR has to be updated too, a signal must not split them.
+	mutateCtx := context.WithoutCancel(ctx)
 
-	waitReleaseFunc := func() error {
-		time.Sleep(5 * time.Second)
-		wrapper := helmwrapper.NewHelmWrapper(string(r.Kubeconfig), old.Namespace, old.Name)
-		ready, err := wrapper.IsReleaseReady(5 * time.Minute)
+	reconcile := &Reconcile{Revision: record.Revision, Generation: old.Generation, AppVersion: old.Spec.AppVersion}
+	if !record.Phase.Reached(PhaseIngressClassDeleting) {
+		revision, err := CurrentRevision(r.Kubeconfig, old.Namespace, old.Name)
 		if err != nil {
 			return err
 		}
-		if !ready {
-			return fmt.Errorf("gateway '%s/%s' is not ready, wait for release timeout", old.Namespace, old.Name)
+		ingressClassName, err := IngressClassOf(mutateCtx, r.Client, old.Name)
+		if err != nil {
+			return err
+		}
+		record.Phase, record.Revision, record.IngressClass = PhaseIngressClassDeleting, revision, ingressClassName
+		err = r.saveRecord(mutateCtx, &old, *record)
+		if err != nil {
+			return err
 		}
-		return nil
 	}
-
-	values, err := r.valueOverride(ctx, jsonBytes)
+	if !record.Phase.Reached(PhaseIngressClassDeleted) {
+		// An earlier attempt may have deleted it before it was evicted.
+		err := r.Client.Delete(mutateCtx, &networkingv1.IngressClass{ObjectMeta: metav1.ObjectMeta{Name: record.IngressClass}})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+		klog.InfoS("Deleted old ingress class", r.logKeys(old.Namespace, old.Name, "phase", record.Phase, "ingressClass", record.IngressClass)...)
+		r.event(&old, corev1.EventTypeNormal, EventReasonIngressClassReplaced,
+			"Deleted IngressClass %s, the gateway controller recreates it for %s", record.IngressClass, r.RunOptions.TargetVersion)
+		record.Phase = PhaseIngressClassDeleted
+		err = r.saveRecord(mutateCtx, &old, *record)
+		if err != nil {
+			return err
+		}
+	}
+	if !record.Phase.Reached(PhaseCRUpdated) {
+		updated, err := r.updateGateway(mutateCtx, old)
+		if err != nil {
+			return err
+		}
+		r.event(&old, corev1.EventTypeNormal, EventReasonGatewayUpdated, "Updated app version from %s to %s", old.Spec.AppVersion, r.RunOptions.TargetVersion)
+		record.Phase = PhaseCRUpdated
+		err = r.saveRecord(mutateCtx, &old, *record)
+		if err != nil {
+			return err
+		}
+		reconcile = ReconcileOf(updated, old.Generation, record.Revision)
+	}
+	err := WaitForRelease(ctx, r.Client, r.Kubeconfig, old.Namespace, old.Name, reconcile, r.RunOptions.Wait)
+	if ctx.Err() != nil {
+		return fmt.Errorf("interrupted while waiting for the release, the gateway CR is already updated to %s: %w", r.RunOptions.TargetVersion, ctx.Err())
+	}
 	if err != nil {
 		return err
 	}
+	klog.InfoS("Gateway release is ready", r.logKeys(old.Namespace, old.Name, "phase", record.Phase)...)
 
-	deepCopy := old.DeepCopy()
-	deepCopy.Spec.AppVersion = TargetVersion
-	deepCopy.Spec.Values = runtime.RawExtension{Raw: values}
+	return nil
+}
 
-	ingressClassList := &v1.IngressClassList{}
-	err = r.Client.List(ctx, ingressClassList, client.MatchingLabels{"app.kubernetes.io/instance": old.Name})
+// updateGateway updates the Gateway CR to RunOptions.TargetVersion with the values derived from old.
+func (r *Runner) updateGateway(ctx context.Context, old gatewayv2alpha2.Gateway) (*gatewayv2alpha2.Gateway, error) {
+	service := &corev1.Service{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: old.Namespace, Name: old.Name}, service)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if len(ingressClassList.Items) == 0 {
-		return fmt.Errorf("get gateway: %s ingressClass failed, please check it", old.Name)
+	gatewayConfig, err := GetGatewayConfig(ctx, r.Client)
+	if err != nil {
+		return nil, err
 	}
-	oldIngressClassName := ingressClassList.Items[0].Name
-
-	err = r.Client.Delete(ctx, &v1.IngressClass{ObjectMeta: metav1.ObjectMeta{Name: oldIngressClassName}})
+	values, err := TargetValues(&old, service, gatewayConfig)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	klog.Infof("Delete old ingress class %s successfully.", oldIngressClassName)
+
+	deepCopy := old.DeepCopy()
+	deepCopy.Spec.AppVersion = r.RunOptions.TargetVersion
+	deepCopy.Spec.Values = runtime.RawExtension{Raw: values}
 	err = r.Client.Update(ctx, deepCopy)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = waitReleaseFunc()
-	if err != nil {
-		return err
+	return deepCopy, nil
+}
+
+// logKeys returns the structured logging keys of the run and of the gateway, if name is set,
+// followed by keysAndValues.
+func (r *Runner) logKeys(namespace, name string, keysAndValues ...interface{}) []interface{} {
+	keys := make([]interface{}, 0, 6+len(keysAndValues))
+	if r.checkpoint != nil {
+		keys = append(keys, "runID", r.checkpoint.ID())
 	}
-	klog.Infof("Update gateway CR successfully, gateway: %s/%s", old.Namespace, old.Name)
+	if name != "" {
+		keys = append(keys, "namespace", namespace, "gateway", name)
+	}
+	return append(keys, keysAndValues...)
+}
 
-	return nil
+func (r *Runner) record(gw *gatewayv2alpha2.Gateway) GatewayRecord {
+	if r.checkpoint == nil {
+		return GatewayRecord{}
+	}
+	return r.checkpoint.Record(gw)
 }
 
-func (r *Runner) valueOverride(ctx context.Context, values []byte) ([]byte, error) {
-	valuesMap := map[string]interface{}{}
-	err := json.Unmarshal(values, &valuesMap)
+// fromVersion returns the app version the gateway had before the run, the live one may have been
+// updated by an earlier attempt.
+func fromVersion(gw *gatewayv2alpha2.Gateway, record GatewayRecord) string {
+	if record.FromVersion != "" {
+		return record.FromVersion
+	}
+	return gw.Spec.AppVersion
+}
+
+func (r *Runner) saveRecord(ctx context.Context, gw *gatewayv2alpha2.Gateway, record GatewayRecord) error {
+	if r.checkpoint == nil {
+		return nil
+	}
+	return r.checkpoint.SetRecord(ctx, gw, record)
+}
+
+// TargetValues returns the values the gateway is upgraded with. The node ports of a NodePort
+// service are kept as gateway annotations, service may be nil if it is not available.
+// gatewayConfig is the config.yaml of the gateway-agent-backend-config ConfigMap.
+func TargetValues(gw *gatewayv2alpha2.Gateway, service *corev1.Service, gatewayConfig string) ([]byte, error) {
+	if service != nil && service.Spec.Type == corev1.ServiceTypeNodePort {
+		if gw.Annotations == nil {
+			gw.Annotations = map[string]string{}
+		}
+		for _, port := range service.Spec.Ports {
+			if port.Name == "http" {
+				gw.Annotations[template.AnnotationsNodePortHttp] = strconv.Itoa(int(port.NodePort))
+			}
+			if port.Name == "https" {
+				gw.Annotations[template.AnnotationsNodePortHttps] = strconv.Itoa(int(port.NodePort))
+			}
+		}
+	}
+
+	jsonBytes, err := template.HandleTemplate(gw)
 	if err != nil {
 		return nil, err
 	}
+	return OverrideValues(jsonBytes, gatewayConfig)
+}
 
+func GetGatewayConfig(ctx context.Context, c client.Client) (string, error) {
 	gatewayCm := &corev1.ConfigMap{}
-	err = r.Client.Get(ctx, types.NamespacedName{Namespace: ExtensionNamespace, Name: GatewayConfigMapName}, gatewayCm)
+	err := c.Get(ctx, types.NamespacedName{Namespace: ExtensionNamespace, Name: GatewayConfigMapName}, gatewayCm)
+	if err != nil {
+		return "", err
+	}
+	return gatewayCm.Data["config.yaml"], nil
+}
+
+func OverrideValues(values []byte, gatewayConfig string) ([]byte, error) {
+	valuesMap := map[string]interface{}{}
+	err := json.Unmarshal(values, &valuesMap)
 	if err != nil {
 		return nil, err
 	}
 
 	overrideOptions := &GatewayConfig{}
-	config := gatewayCm.Data["config.yaml"]
-	err = yaml.Unmarshal([]byte(config), overrideOptions)
+	err = yaml.Unmarshal([]byte(gatewayConfig), overrideOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -284,51 +687,36 @@ type GatewayConfig struct {
 	Gateway OverrideOptions `yaml:"gateway"`
 }
 
-func buildClient(kubeconfig string) (client.Client, error) {
-	var config *rest.Config
-	var err error
-	if kubeconfig == "" {
-		config, err = rest.InClusterConfig()
-	} else {
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-	}
+// backupGateways writes and verifies the backup of gateways.
+func (r *Runner) backupGateways(gateways []gatewayv2alpha2.Gateway) (string, error) {
+	klog.InfoS("Start to backup gateways", r.logKeys("", "", "gateways", gatewayNames(gateways))...)
+	backupPath, err := r.CreateBackupFile(gateways)
 	if err != nil {
-		return nil, err
+		return "", fmt.Errorf("failed to backup gateways: %w", err)
 	}
-
-	return client.New(config, client.Options{Scheme: scheme.Scheme})
+	return backupPath, nil
 }
 
-func (r *Runner) CreateBackupFile(gateways []gatewayv2alpha2.Gateway) error {
-	backupDir := r.RunOptions.Backup.Dir
-	fullPath := filepath.Join(backupDir, fmt.Sprintf("gateway-backup-%s.yaml", time.Now().Format("20060102150405")))
-
-	err := os.MkdirAll(backupDir, 0755)
+func (r *Runner) CreateBackupFile(gateways []gatewayv2alpha2.Gateway) (string, error) {
+	backupOptions := r.RunOptions.Backup
+	fullPath, err := backup.Write(backupOptions.Dir, backupOptions.EncryptionKeyFile, gateways)
 	if err != nil {
-		return err
+		return "", err
 	}
-	file, err := os.OpenFile(fullPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		return err
-	}
-
-	defer file.Close()
-
-	for _, gateway := range gateways {
-		marshal, err := yaml.Marshal(gateway)
+	if backupOptions.EncryptionKeyFile != "" {
+		key, err := backup.LoadKey(backupOptions.EncryptionKeyFile)
 		if err != nil {
-			return err
+			return "", err
 		}
-		_, err = file.Write(marshal)
-		if err != nil {
-			return err
+		if !key.CanDecrypt() {
+			logging.WarningS("Backup is encrypted with a public key, skip verifying it", r.logKeys("", "", "backup", fullPath)...)
+			return fullPath, nil
 		}
-		_, err = file.WriteString("\n---\n")
-		if err != nil {
-			return err
-		}
-		klog.Info("Backup gateway successfully. gateway: ", fmt.Sprintf(gateway.Name))
 	}
-
-	return nil
+	err = backup.Verify(fullPath, backupOptions.EncryptionKeyFile, gateways)
+	if err != nil {
+		return "", fmt.Errorf("failed to verify backup: %w", err)
+	}
+	klog.InfoS("Backup verified", r.logKeys("", "", "backup", fullPath)...)
+	return fullPath, nil
 }
@@ -0,0 +1,181 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
+)
+
+// testKubeconfig points helm at a server which refuses connections, so the gateways which get as
+// far as helm fail at once instead of reaching the cluster of the default kubeconfig.
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://127.0.0.1:1
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`
+
+// slowRecorder takes a while to record every Event, which keeps the gateways in flight long enough
+// to count how many are upgraded at once.
+type slowRecorder struct {
+	mu                    sync.Mutex
+	inFlight, maxInFlight int
+}
+
+func (r *slowRecorder) Event(object runtime.Object, eventType, reason, message string) {
+	r.mu.Lock()
+	r.inFlight++
+	r.maxInFlight = max(r.maxInFlight, r.inFlight)
+	r.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	r.mu.Lock()
+	r.inFlight--
+	r.mu.Unlock()
+}
+
+func (r *slowRecorder) Eventf(object runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	r.Event(object, eventType, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (r *slowRecorder) AnnotatedEventf(object runtime.Object, _ map[string]string, eventType, reason, messageFmt string, args ...interface{}) {
+	r.Eventf(object, eventType, reason, messageFmt, args...)
+}
+
+func TestUpgradeGatewaysConcurrency(t *testing.T) {
+	var gateways []gatewayv2alpha2.Gateway
+	var want []string
+	for i := 0; i < 8; i++ {
+		gw := healthyGateway(t, "a", fmt.Sprintf("gw%d", i), TargetVersion, true)
+		gateways = append(gateways, gw)
+		want = append(want, "a/"+gw.Name)
+	}
+	tests := []struct {
+		concurrency int
+		want        int
+	}{
+		{concurrency: 0, want: 1},
+		{concurrency: 1, want: 1},
+		{concurrency: 3, want: 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("concurrency %d", tt.concurrency), func(t *testing.T) {
+			recorder := &slowRecorder{}
+			runOptions := options.NewRunOptions()
+			runOptions.Concurrency = tt.concurrency
+			r := &Runner{RunOptions: *runOptions, Recorder: recorder}
+
+			results, err := r.UpgradeGateways(context.Background(), gateways)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, result := range results {
+				got = append(got, result.FullName())
+				if result.Outcome != OutcomeSkipped || !result.UpToDate {
+					t.Errorf("result of %s = %+v, want skipped as up to date", result.FullName(), result)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("results = %v, want them in the order of the gateways %v", got, want)
+			}
+			if recorder.maxInFlight > tt.want || tt.want > 1 && recorder.maxInFlight < 2 {
+				t.Errorf("%d gateways were upgraded at once, want up to %d", recorder.maxInFlight, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeGatewaysFailure(t *testing.T) {
+	// Detecting the drift of gw2 and gw4 fails, their releases are out of reach.
+	gateways := []gatewayv2alpha2.Gateway{
+		healthyGateway(t, "a", "gw1", TargetVersion, true),
+		healthyGateway(t, "a", "gw2", testFromVersion, true),
+		healthyGateway(t, "a", "gw3", TargetVersion, true),
+		healthyGateway(t, "a", "gw4", testFromVersion, true),
+	}
+	tests := []struct {
+		name            string
+		continueOnError bool
+		want            []Outcome
+		wantFailed      []string
+	}{
+		{
+			name:       "abort",
+			want:       []Outcome{OutcomeSkipped, OutcomeFailed, OutcomeNotStarted, OutcomeNotStarted},
+			wantFailed: []string{"a/gw2"},
+		},
+		{
+			name:            "continue on error",
+			continueOnError: true,
+			want:            []Outcome{OutcomeSkipped, OutcomeFailed, OutcomeSkipped, OutcomeFailed},
+			wantFailed:      []string{"a/gw2", "a/gw4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runOptions := options.NewRunOptions()
+			runOptions.Concurrency, runOptions.ContinueOnError = 1, tt.continueOnError
+			r := &Runner{RunOptions: *runOptions, Kubeconfig: []byte(testKubeconfig)}
+
+			results, err := r.UpgradeGateways(context.Background(), gateways)
+			var errs []error
+			if agg, ok := err.(utilerrors.Aggregate); ok {
+				errs = agg.Errors()
+			}
+			if len(errs) != len(tt.wantFailed) {
+				t.Fatalf("UpgradeGateways() error = %v, want the failures of %v", err, tt.wantFailed)
+			}
+			for i, name := range tt.wantFailed {
+				if !strings.Contains(errs[i].Error(), "failed to upgrade gateway "+name) {
+					t.Errorf("error %d = %v, want the failure of %s", i, errs[i], name)
+				}
+			}
+			var outcomes []Outcome
+			for _, result := range results {
+				outcomes = append(outcomes, result.Outcome)
+				if result.Outcome == OutcomeNotStarted && result.Reason != "an earlier gateway failed" {
+					t.Errorf("reason of %s = %q, want an earlier gateway failed", result.FullName(), result.Reason)
+				}
+			}
+			if !reflect.DeepEqual(outcomes, tt.want) {
+				t.Errorf("outcomes = %v, want %v", outcomes, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeGatewaysInterrupted(t *testing.T) {
+	gateways := []gatewayv2alpha2.Gateway{
+		healthyGateway(t, "a", "gw1", testFromVersion, true),
+		healthyGateway(t, "a", "gw2", testFromVersion, true),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := &Runner{RunOptions: *options.NewRunOptions(), Kubeconfig: []byte(testKubeconfig)}
+
+	results, err := r.UpgradeGateways(ctx, gateways)
+	if err == nil || !strings.Contains(err.Error(), "upgrade interrupted before gateway a/gw1") {
+		t.Errorf("UpgradeGateways() error = %v, want it interrupted before a/gw1", err)
+	}
+	for _, result := range results {
+		if result.Outcome != OutcomeNotStarted || result.FromVersion != testFromVersion {
+			t.Errorf("result of %s = %+v, want not started from %s", result.FullName(), result, testFromVersion)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package upgrade
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/logging"
+)
+
+const (
+	SmokeTestViaClusterIP    = "clusterip"
+	SmokeTestViaNodePort     = "nodeport"
+	SmokeTestViaLoadBalancer = "loadbalancer"
+)
+
+// SmokeTest is a request sent through the gateway after it was upgraded.
+type SmokeTest struct {
+	Scheme string
+	Host   string
+	Path   string
+	// ExpectedStatus of 0 accepts any status below 500
+	ExpectedStatus int
+}
+
+func (t SmokeTest) String() string {
+	return fmt.Sprintf("%s://%s%s", t.Scheme, t.Host, t.Path)
+}
+
+// ParseSmokeTest parses [scheme://]host[/path][=status], e.g. https://example.com/healthz=200. Only
+// a numeric suffix after the last '=' is the status, the path may contain a query with '='.
+func ParseSmokeTest(s string) (SmokeTest, error) {
+	test := SmokeTest{Scheme: "http", Path: "/"}
+	if i := strings.LastIndex(s, "="); i >= 0 {
+		if code, err := strconv.Atoi(s[i+1:]); err == nil {
+			if code < 100 || code > 599 {
+				return test, fmt.Errorf("invalid status code in smoke test %q", s)
+			}
+			test.ExpectedStatus = code
+			s = s[:i]
+		}
+	}
+	if scheme, rest, ok := strings.Cut(s, "://"); ok {
+		if scheme != "http" && scheme != "https" {
+			return test, fmt.Errorf("invalid scheme in smoke test %q", s)
+		}
+		test.Scheme = scheme
+		s = rest
+	}
+	host, path, ok := strings.Cut(s, "/")
+	if ok {
+		test.Path = "/" + path
+	}
+	test.Host = host
+	return test, nil
+}
+
+// SmokeTarget is where the smoke test requests are sent to, host:port per scheme.
+type SmokeTarget struct {
+	HTTP  string
+	HTTPS string
+}
+
+type SmokeTester struct {
+	Client *http.Client
+}
+
+func NewSmokeTester(timeout time.Duration) *SmokeTester {
+	return &SmokeTester{Client: &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// The gateway certificates are often self-signed, the test is about routing not trust.
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}}
+}
+
+// Run sends every test to target and returns the failed ones.
+func (t *SmokeTester) Run(ctx context.Context, target SmokeTarget, tests []SmokeTest) error {
+	var errs []error
+	for _, test := range tests {
+		address := target.HTTP
+		if test.Scheme == "https" {
+			address = target.HTTPS
+		}
+		if address == "" {
+			errs = append(errs, fmt.Errorf("%s: the gateway has no %s address", test, test.Scheme))
+			continue
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s%s", test.Scheme, address, test.Path), nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		req.Host = test.Host
+		if test.Scheme == "https" && test.Host != "" {
+			transport := t.Client.Transport.(*http.Transport).Clone()
+			transport.TLSClientConfig.ServerName = test.Host
+			client := *t.Client
+			client.Transport = transport
+			err = expectStatus(&client, req, test)
+		} else {
+			err = expectStatus(t.Client, req, test)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+func expectStatus(c *http.Client, req *http.Request, test SmokeTest) error {
+	resp, err := c.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %v", test, err)
+	}
+	resp.Body.Close()
+	if test.ExpectedStatus == 0 && resp.StatusCode >= 500 {
+		return fmt.Errorf("%s: got status %d", test, resp.StatusCode)
+	}
+	if test.ExpectedStatus != 0 && resp.StatusCode != test.ExpectedStatus {
+		return fmt.Errorf("%s: got status %d, expected %d", test, resp.StatusCode, test.ExpectedStatus)
+	}
+	return nil
+}
+
+// smokeTests returns the configured tests plus, if enabled, one per host and path of the Ingresses
+// served by the gateway.
+func (r *Runner) smokeTests(ctx context.Context, gw *gatewayv2alpha2.Gateway) ([]SmokeTest, error) {
+	smokeOptions := r.RunOptions.SmokeTest
+	var tests []SmokeTest
+	for _, s := range smokeOptions.Tests {
+		test, err := ParseSmokeTest(s)
+		if err != nil {
+			return nil, err
+		}
+		tests = append(tests, test)
+	}
+	if !smokeOptions.FromIngresses {
+		return tests, nil
+	}
+
+	ingressClassList := &v1.IngressClassList{}
+	err := r.Client.List(ctx, ingressClassList, client.MatchingLabels{"app.kubernetes.io/instance": gw.Name})
+	if err != nil {
+		return nil, err
+	}
+	classes := map[string]bool{}
+	for _, ingressClass := range ingressClassList.Items {
+		classes[ingressClass.Name] = true
+	}
+	ingressList := &v1.IngressList{}
+	err = r.Client.List(ctx, ingressList)
+	if err != nil {
+		return nil, err
+	}
+	for _, ingress := range ingressList.Items {
+		if ingress.Spec.IngressClassName == nil || !classes[*ingress.Spec.IngressClassName] {
+			continue
+		}
+		tlsHosts := map[string]bool{}
+		for _, t := range ingress.Spec.TLS {
+			for _, host := range t.Hosts {
+				tlsHosts[host] = true
+			}
+		}
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			scheme := "http"
+			if tlsHosts[rule.Host] {
+				scheme = "https"
+			}
+			for _, path := range rule.HTTP.Paths {
+				// Regex paths cannot be requested as they are.
+				if path.PathType != nil && *path.PathType == v1.PathTypeImplementationSpecific {
+					continue
+				}
+				tests = append(tests, SmokeTest{Scheme: scheme, Host: rule.Host, Path: path.Path})
+			}
+		}
+	}
+	return tests, nil
+}
+
+// smokeTarget resolves the address of the gateway service the requests are sent to.
+func (r *Runner) smokeTarget(ctx context.Context, gw *gatewayv2alpha2.Gateway) (SmokeTarget, error) {
+	smokeOptions := r.RunOptions.SmokeTest
+	if smokeOptions.Address != "" {
+		return SmokeTarget{HTTP: smokeOptions.Address, HTTPS: smokeOptions.Address}, nil
+	}
+
+	service := &corev1.Service{}
+	err := r.Client.Get(ctx, types.NamespacedName{Namespace: gw.Namespace, Name: gw.Name}, service)
+	if err != nil {
+		return SmokeTarget{}, err
+	}
+
+	var host string
+	nodePort := false
+	switch smokeOptions.Via {
+	case SmokeTestViaClusterIP, "":
+		host = service.Spec.ClusterIP
+	case SmokeTestViaLoadBalancer:
+		status, err := gw.GetStatus()
+		if err != nil {
+			return SmokeTarget{}, err
+		}
+		ingress := status.LoadBalancer.Ingress
+		if len(ingress) == 0 {
+			ingress = service.Status.LoadBalancer.Ingress
+		}
+		if len(ingress) == 0 {
+			return SmokeTarget{}, errors.New("the gateway has no load balancer address")
+		}
+		host = ingress[0].IP
+		if host == "" {
+			host = ingress[0].Hostname
+		}
+	case SmokeTestViaNodePort:
+		nodeList := &corev1.NodeList{}
+		err := r.Client.List(ctx, nodeList)
+		if err != nil {
+			return SmokeTarget{}, err
+		}
+		for _, node := range nodeList.Items {
+			for _, address := range node.Status.Addresses {
+				if address.Type == corev1.NodeInternalIP && host == "" {
+					host = address.Address
+				}
+			}
+		}
+		if host == "" {
+			return SmokeTarget{}, errors.New("no node internal IP found")
+		}
+		nodePort = true
+	default:
+		return SmokeTarget{}, fmt.Errorf("unknown smoke test target %q", smokeOptions.Via)
+	}
+
+	target := SmokeTarget{}
+	for _, port := range service.Spec.Ports {
+		number := port.Port
+		if nodePort {
+			number = port.NodePort
+		}
+		switch port.Name {
+		case "http":
+			target.HTTP = net.JoinHostPort(host, strconv.Itoa(int(number)))
+		case "https":
+			target.HTTPS = net.JoinHostPort(host, strconv.Itoa(int(number)))
+		}
+	}
+	return target, nil
+}
+
+// SmokeTestEnabled reports whether any smoke test is configured.
+func SmokeTestEnabled(smokeOptions *options.SmokeTestOptions) bool {
+	return smokeOptions != nil && (len(smokeOptions.Tests) > 0 || smokeOptions.FromIngresses)
+}
+
+func (r *Runner) runSmokeTests(ctx context.Context, gw *gatewayv2alpha2.Gateway) error {
+	tests, err := r.smokeTests(ctx, gw)
+	if err != nil {
+		return err
+	}
+	if len(tests) == 0 {
+		logging.WarningS("Gateway has no smoke test to run", r.logKeys(gw.Namespace, gw.Name)...)
+		return nil
+	}
+	target, err := r.smokeTarget(ctx, gw)
+	if err != nil {
+		return err
+	}
+	klog.InfoS("Run smoke tests", r.logKeys(gw.Namespace, gw.Name, "tests", len(tests), "http", target.HTTP, "https", target.HTTPS)...)
+	return NewSmokeTester(r.RunOptions.SmokeTest.Timeout).Run(ctx, target, tests)
+}
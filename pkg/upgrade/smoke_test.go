@@ -0,0 +1,89 @@
+package upgrade
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSmokeTest(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    SmokeTest
+		wantErr bool
+	}{
+		{in: "example.com", want: SmokeTest{Scheme: "http", Host: "example.com", Path: "/"}},
+		{in: "https://example.com/healthz=200", want: SmokeTest{Scheme: "https", Host: "example.com", Path: "/healthz", ExpectedStatus: 200}},
+		{in: "example.com/search?q=a=200", want: SmokeTest{Scheme: "http", Host: "example.com", Path: "/search?q=a", ExpectedStatus: 200}},
+		{in: "example.com/search?q=a", want: SmokeTest{Scheme: "http", Host: "example.com", Path: "/search?q=a"}},
+		{in: "example.com/a=b&c=d", want: SmokeTest{Scheme: "http", Host: "example.com", Path: "/a=b&c=d"}},
+		{in: "example.com=404", want: SmokeTest{Scheme: "http", Host: "example.com", Path: "/", ExpectedStatus: 404}},
+		{in: "example.com=999", wantErr: true},
+		{in: "ftp://example.com", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSmokeTest(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSmokeTest(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("ParseSmokeTest(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSmokeTesterRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Host != "example.com":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/healthz":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		case r.URL.Path == "/slow":
+			time.Sleep(time.Second)
+		}
+	}))
+	defer server.Close()
+	target := SmokeTarget{HTTP: strings.TrimPrefix(server.URL, "http://")}
+
+	tests := []struct {
+		name    string
+		test    string
+		wantErr string
+	}{
+		{name: "expected status", test: "example.com/healthz=200"},
+		{name: "status mismatch", test: "example.com/missing=200", wantErr: "got status 404, expected 200"},
+		{name: "default accepts 4xx", test: "example.com/missing"},
+		{name: "default rejects 5xx", test: "example.com/broken", wantErr: "got status 502"},
+		{name: "host header", test: "other.com/healthz=200", wantErr: "got status 404, expected 200"},
+		{name: "timeout", test: "example.com/slow", wantErr: "Client.Timeout"},
+		{name: "no https address", test: "https://example.com/healthz", wantErr: "no https address"},
+	}
+	tester := NewSmokeTester(200 * time.Millisecond)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			test, err := ParseSmokeTest(tt.test)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tester.Run(context.Background(), target, []SmokeTest{test})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/simple/helmwrapper"
+)
+
+const (
+	StatusFormatTable = "table"
+	StatusFormatJSON  = "json"
+	StatusFormatYAML  = "yaml"
+)
+
+// GatewayStatus is the inventory entry of a gateway printed by the status command.
+type GatewayStatus struct {
+	Namespace  string `json:"namespace"`
+	Name       string `json:"name"`
+	AppVersion string `json:"appVersion"`
+	// UpToDate is whether AppVersion is the target version.
+	UpToDate        bool          `json:"upToDate"`
+	Deployed        string        `json:"deployed"`
+	DeploymentReady string        `json:"deploymentReady"`
+	ServiceType     string        `json:"serviceType,omitempty"`
+	Ports           []ServicePort `json:"ports,omitempty"`
+	IngressClass    string        `json:"ingressClass,omitempty"`
+	// ReleaseRevision is 0 if the gateway has no helm release.
+	ReleaseRevision int    `json:"releaseRevision,omitempty"`
+	ReleaseStatus   string `json:"releaseStatus,omitempty"`
+	// UpgradeStatus is the gateway.kubesphere.io/upgrade-status annotation.
+	UpgradeStatus string `json:"upgradeStatus,omitempty"`
+	// Errors are the lookups which failed for the gateway.
+	Errors []string `json:"errors,omitempty"`
+}
+
+type ServicePort struct {
+	Name     string          `json:"name,omitempty"`
+	Port     int32           `json:"port"`
+	NodePort int32           `json:"nodePort,omitempty"`
+	Protocol corev1.Protocol `json:"protocol"`
+}
+
+// GatewayStatuses collects the status of the gateways referenced by refs, or of every gateway if
+// refs is empty, and whether they run targetVersion. A gateway whose service, IngressClass or release can't be read is still listed,
+// with the failures in Errors.
+func GatewayStatuses(ctx context.Context, c client.Client, kubeconfig []byte, refs []*gatewayv2alpha2.GatewayReference, targetVersion string) ([]GatewayStatus, error) {
+	var gateways []gatewayv2alpha2.Gateway
+	if len(refs) == 0 {
+		gatewayList := &gatewayv2alpha2.GatewayList{}
+		if err := c.List(ctx, gatewayList); err != nil {
+			return nil, err
+		}
+		gateways = gatewayList.Items
+	} else {
+		for _, ref := range refs {
+			gateway := &gatewayv2alpha2.Gateway{}
+			if err := c.Get(ctx, ref.ToNamespacedName(), gateway); err != nil {
+				return nil, err
+			}
+			gateways = append(gateways, *gateway)
+		}
+	}
+	sort.Slice(gateways, func(i, j int) bool {
+		if gateways[i].Namespace != gateways[j].Namespace {
+			return gateways[i].Namespace < gateways[j].Namespace
+		}
+		return gateways[i].Name < gateways[j].Name
+	})
+
+	statuses := make([]GatewayStatus, 0, len(gateways))
+	for i := range gateways {
+		statuses = append(statuses, gatewayStatus(ctx, c, kubeconfig, &gateways[i], targetVersion))
+	}
+	return statuses, nil
+}
+
+func gatewayStatus(ctx context.Context, c client.Client, kubeconfig []byte, gw *gatewayv2alpha2.Gateway, targetVersion string) GatewayStatus {
+	status := GatewayStatus{
+		Namespace:       gw.Namespace,
+		Name:            gw.Name,
+		AppVersion:      gw.Spec.AppVersion,
+		UpToDate:        gw.Spec.AppVersion == targetVersion,
+		Deployed:        conditionStatus(gw, gatewayv2alpha2.ConditionTypeDeployd),
+		DeploymentReady: conditionStatus(gw, gatewayv2alpha2.ConditionTypeDeploymentReady),
+		UpgradeStatus:   gw.Annotations[gatewayv2alpha2.AnnotationsUpgradeStatus],
+	}
+
+	service := &corev1.Service{}
+	if err := c.Get(ctx, types.NamespacedName{Namespace: gw.Namespace, Name: gw.Name}, service); err != nil {
+		status.Errors = append(status.Errors, fmt.Sprintf("get service: %v", err))
+	} else {
+		status.ServiceType = string(service.Spec.Type)
+		for _, port := range service.Spec.Ports {
+			status.Ports = append(status.Ports, ServicePort{Name: port.Name, Port: port.Port, NodePort: port.NodePort, Protocol: port.Protocol})
+		}
+	}
+
+	ingressClassList := &v1.IngressClassList{}
+	if err := c.List(ctx, ingressClassList, client.MatchingLabels{"app.kubernetes.io/instance": gw.Name}); err != nil {
+		status.Errors = append(status.Errors, fmt.Sprintf("list ingress classes: %v", err))
+	} else if len(ingressClassList.Items) > 0 {
+		status.IngressClass = ingressClassList.Items[0].Name
+	}
+
+	rel, err := helmwrapper.NewHelmWrapper(string(kubeconfig), gw.Namespace, gw.Name).Status()
+	switch {
+	case err != nil && err.Error() == helmwrapper.StatusNotFoundFormat:
+	case err != nil:
+		status.Errors = append(status.Errors, fmt.Sprintf("get release: %v", err))
+	default:
+		status.ReleaseRevision = rel.Version
+		if rel.Info != nil {
+			status.ReleaseStatus = rel.Info.Status.String()
+		}
+	}
+	return status
+}
+
+// conditionStatus returns the status of the condition, Unknown if the gateway doesn't report it.
+func conditionStatus(gw *gatewayv2alpha2.Gateway, conditionType string) string {
+	status, err := gw.GetStatus()
+	if err != nil {
+		return string(metav1.ConditionUnknown)
+	}
+	for _, condition := range status.Conditions {
+		if condition.Type == conditionType {
+			return string(condition.Status)
+		}
+	}
+	return string(metav1.ConditionUnknown)
+}
+
+// PrintStatuses writes the statuses to out as a table, JSON or YAML.
+func PrintStatuses(out io.Writer, statuses []GatewayStatus, format string) error {
+	switch format {
+	case StatusFormatJSON:
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(statuses)
+	case StatusFormatYAML:
+		data, err := yaml.Marshal(statuses)
+		if err != nil {
+			return err
+		}
+		_, err = out.Write(data)
+		return err
+	case StatusFormatTable, "":
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAMESPACE\tNAME\tAPP VERSION\tUP TO DATE\tDEPLOYED\tREADY\tSERVICE\tPORTS\tINGRESS CLASS\tREVISION\tRELEASE\tUPGRADE STATUS")
+		for _, status := range statuses {
+			revision := "-"
+			if status.ReleaseRevision > 0 {
+				revision = strconv.Itoa(status.ReleaseRevision)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				status.Namespace, status.Name, dash(status.AppVersion), status.UpToDate, status.Deployed, status.DeploymentReady,
+				dash(status.ServiceType), dash(ports(status.Ports)), dash(status.IngressClass), revision,
+				dash(status.ReleaseStatus), dash(status.UpgradeStatus))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			for _, e := range status.Errors {
+				fmt.Fprintf(out, "%s/%s: %s\n", status.Namespace, status.Name, e)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output format %q, use %s, %s or %s", format, StatusFormatTable, StatusFormatJSON, StatusFormatYAML)
+	}
+}
+
+// ports formats the ports like kubectl, e.g. 80:30080/TCP,443:30443/TCP.
+func ports(servicePorts []ServicePort) string {
+	var formatted []string
+	for _, port := range servicePorts {
+		s := strconv.Itoa(int(port.Port))
+		if port.NodePort > 0 {
+			s += ":" + strconv.Itoa(int(port.NodePort))
+		}
+		formatted = append(formatted, s+"/"+string(port.Protocol))
+	}
+	return strings.Join(formatted, ",")
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
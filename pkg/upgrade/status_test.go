@@ -0,0 +1,142 @@
+package upgrade
+
+import (
+	"bytes"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func testStatuses() []GatewayStatus {
+	return []GatewayStatus{
+		{
+			Namespace: "a", Name: "gw1", AppVersion: TargetVersion, UpToDate: true, Deployed: "True", DeploymentReady: "True",
+			ServiceType: "NodePort", Ports: []ServicePort{{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP}},
+			IngressClass: "gw1", ReleaseRevision: 3, ReleaseStatus: "deployed",
+		},
+		{
+			Namespace: "b", Name: "gw2", Deployed: "Unknown", DeploymentReady: "Unknown",
+			Errors: []string{"get service: not found"},
+		},
+	}
+}
+
+func TestPrintStatuses(t *testing.T) {
+	tests := []struct {
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{
+			format: StatusFormatTable,
+			want: "NAMESPACE  NAME  APP VERSION                      UP TO DATE  DEPLOYED  READY    SERVICE   PORTS         INGRESS CLASS  REVISION  RELEASE   UPGRADE STATUS\n" +
+				"a          gw1   " + TargetVersion + "  true        True      True     NodePort  80:30080/TCP  gw1            3         deployed  -\n" +
+				"b          gw2   -                                false       Unknown   Unknown  -         -             -              -         -         -\n" +
+				"b/gw2: get service: not found\n",
+		},
+		{
+			format: StatusFormatJSON,
+			want: `[
+  {
+    "namespace": "a",
+    "name": "gw1",
+    "appVersion": "` + TargetVersion + `",
+    "upToDate": true,
+    "deployed": "True",
+    "deploymentReady": "True",
+    "serviceType": "NodePort",
+    "ports": [
+      {
+        "name": "http",
+        "port": 80,
+        "nodePort": 30080,
+        "protocol": "TCP"
+      }
+    ],
+    "ingressClass": "gw1",
+    "releaseRevision": 3,
+    "releaseStatus": "deployed"
+  },
+  {
+    "namespace": "b",
+    "name": "gw2",
+    "appVersion": "",
+    "upToDate": false,
+    "deployed": "Unknown",
+    "deploymentReady": "Unknown",
+    "errors": [
+      "get service: not found"
+    ]
+  }
+]
+`,
+		},
+		{
+			format: StatusFormatYAML,
+			want: `- appVersion: ` + TargetVersion + `
+  deployed: "True"
+  deploymentReady: "True"
+  ingressClass: gw1
+  name: gw1
+  namespace: a
+  ports:
+  - name: http
+    nodePort: 30080
+    port: 80
+    protocol: TCP
+  releaseRevision: 3
+  releaseStatus: deployed
+  serviceType: NodePort
+  upToDate: true
+- appVersion: ""
+  deployed: Unknown
+  deploymentReady: Unknown
+  errors:
+  - 'get service: not found'
+  name: gw2
+  namespace: b
+  upToDate: false
+`,
+		},
+		{format: "wide", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			err := PrintStatuses(&out, testStatuses(), tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PrintStatuses() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if out.String() != tt.want {
+				t.Errorf("PrintStatuses() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestPorts(t *testing.T) {
+	tests := []struct {
+		name  string
+		ports []ServicePort
+		want  string
+	}{
+		{name: "none"},
+		{name: "cluster IP", ports: []ServicePort{{Port: 80, Protocol: corev1.ProtocolTCP}}, want: "80/TCP"},
+		{
+			name: "node ports",
+			ports: []ServicePort{
+				{Name: "http", Port: 80, NodePort: 30080, Protocol: corev1.ProtocolTCP},
+				{Name: "https", Port: 443, NodePort: 30443, Protocol: corev1.ProtocolTCP},
+				{Name: "udp", Port: 53, Protocol: corev1.ProtocolUDP},
+			},
+			want: "80:30080/TCP,443:30443/TCP,53/UDP",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ports(tt.ports); got != tt.want {
+				t.Errorf("ports() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
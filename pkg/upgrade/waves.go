@@ -0,0 +1,211 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+)
+
+const (
+	// LabelUpgradeWave assigns a gateway to a wave when waves are planned by label, lower waves go first.
+	LabelUpgradeWave = "gateway.kubesphere.io/upgrade-wave"
+
+	// WavesCanary upgrades the first gateway alone, then all the others.
+	WavesCanary = "canary"
+	// WavesLabel groups the gateways by LabelUpgradeWave, unlabelled gateways go last.
+	WavesLabel = "label"
+)
+
+// PlanWaves groups the gateways into waves. spec is empty for a single wave, WavesCanary, WavesLabel,
+// or an explicit list of waves separated by ';', each a comma-separated list of gateway names.
+func PlanWaves(gateways []gatewayv2alpha2.Gateway, spec string) ([][]gatewayv2alpha2.Gateway, error) {
+	switch spec {
+	case "":
+		return [][]gatewayv2alpha2.Gateway{gateways}, nil
+	case WavesCanary:
+		if len(gateways) <= 1 {
+			return [][]gatewayv2alpha2.Gateway{gateways}, nil
+		}
+		return [][]gatewayv2alpha2.Gateway{gateways[:1], gateways[1:]}, nil
+	case WavesLabel:
+		return planWavesByLabel(gateways), nil
+	default:
+		return planWavesByList(gateways, spec)
+	}
+}
+
+func planWavesByLabel(gateways []gatewayv2alpha2.Gateway) [][]gatewayv2alpha2.Gateway {
+	byWave := map[string][]gatewayv2alpha2.Gateway{}
+	var unlabelled []gatewayv2alpha2.Gateway
+	for _, gw := range gateways {
+		wave, ok := gw.Labels[LabelUpgradeWave]
+		if !ok {
+			unlabelled = append(unlabelled, gw)
+			continue
+		}
+		byWave[wave] = append(byWave[wave], gw)
+	}
+
+	names := make([]string, 0, len(byWave))
+	for name := range byWave {
+		names = append(names, name)
+	}
+	// Numeric wave names are ordered as numbers so that wave 10 runs after wave 9.
+	sort.Slice(names, func(i, j int) bool {
+		a, errA := strconv.Atoi(names[i])
+		b, errB := strconv.Atoi(names[j])
+		if errA == nil && errB == nil {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+
+	waves := make([][]gatewayv2alpha2.Gateway, 0, len(names)+1)
+	for _, name := range names {
+		waves = append(waves, byWave[name])
+	}
+	if len(unlabelled) > 0 {
+		waves = append(waves, unlabelled)
+	}
+	return waves
+}
+
+func planWavesByList(gateways []gatewayv2alpha2.Gateway, spec string) ([][]gatewayv2alpha2.Gateway, error) {
+	byName := map[types.NamespacedName]gatewayv2alpha2.Gateway{}
+	for _, gw := range gateways {
+		byName[types.NamespacedName{Namespace: gw.Namespace, Name: gw.Name}] = gw
+	}
+
+	var waves [][]gatewayv2alpha2.Gateway
+	planned := map[types.NamespacedName]bool{}
+	for _, waveSpec := range strings.Split(spec, ";") {
+		var wave []gatewayv2alpha2.Gateway
+		for _, ref := range NewGatewayReferences(waveSpec) {
+			namespacedName := ref.ToNamespacedName()
+			gw, ok := byName[namespacedName]
+			if !ok {
+				return nil, fmt.Errorf("gateway %s of --waves is not selected by --gateways", namespacedName)
+			}
+			if planned[namespacedName] {
+				return nil, fmt.Errorf("gateway %s is listed in more than one wave", namespacedName)
+			}
+			planned[namespacedName] = true
+			wave = append(wave, gw)
+		}
+		waves = append(waves, wave)
+	}
+	for namespacedName := range byName {
+		if !planned[namespacedName] {
+			return nil, fmt.Errorf("gateway %s is not listed in any wave", namespacedName)
+		}
+	}
+	return waves, nil
+}
+
+// UpgradeInWaves upgrades the waves one after another. A wave succeeds when none of its gateways
+// failed and the upgraded ones are healthy, after a successful wave RunOptions.SoakTime is observed
+// and the wave is checked once more before the next one starts. A wave followed by another one
+// also has to run the target version on at least one gateway, a canary skipped for any other
+// reason proves nothing. Gateways already at the target version, e.g. upgraded by an earlier run,
+// count like upgraded ones.
+func (r *Runner) UpgradeInWaves(ctx context.Context, gateways []gatewayv2alpha2.Gateway) ([]GatewayResult, error) {
+	waves, err := PlanWaves(gateways, r.RunOptions.Waves)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []GatewayResult
+	for i, wave := range waves {
+		klog.InfoS("Start to upgrade wave", r.logKeys("", "", "wave", i+1, "waves", len(waves), "gateways", gatewayNames(wave))...)
+		waveResults, err := r.UpgradeGateways(ctx, wave)
+		results = append(results, waveResults...)
+		if err == nil && i < len(waves)-1 && !anyAtTarget(waveResults) {
+			err = errors.New("no gateway of the wave was upgraded")
+		}
+		if err == nil {
+			err = r.checkWaveHealth(ctx, waveResults)
+		}
+		if err == nil && i < len(waves)-1 && r.RunOptions.SoakTime > 0 {
+			klog.InfoS("Wave upgraded, soak", r.logKeys("", "", "wave", i+1, "waves", len(waves), "soakTime", r.RunOptions.SoakTime)...)
+			err = sleep(ctx, r.RunOptions.SoakTime)
+			if err == nil {
+				err = r.checkWaveHealth(ctx, waveResults)
+			}
+		}
+		if err != nil {
+			for _, rest := range waves[i+1:] {
+				for _, gw := range rest {
+					results = append(results, GatewayResult{Namespace: gw.Namespace, Name: gw.Name, FromVersion: gw.Spec.AppVersion,
+						Outcome: OutcomeNotStarted, Reason: fmt.Sprintf("wave %d did not succeed", i+1)})
+				}
+			}
+			return results, fmt.Errorf("wave %d/%d did not succeed: %w", i+1, len(waves), err)
+		}
+	}
+	return results, nil
+}
+
+// checkWaveHealth checks that every gateway of a wave running the target version is still
+// deployed and ready.
+func (r *Runner) checkWaveHealth(ctx context.Context, results []GatewayResult) error {
+	var errs []error
+	for _, result := range results {
+		if !atTarget(result) {
+			continue
+		}
+		gw := &gatewayv2alpha2.Gateway{}
+		err := r.Client.Get(ctx, types.NamespacedName{Namespace: result.Namespace, Name: result.Name}, gw)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !gw.IsDeployed() || !gw.IsDeploymentReady() {
+			errs = append(errs, fmt.Errorf("gateway %s is not healthy after the upgrade", result.FullName()))
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// atTarget reports whether the gateway runs the target version after the wave.
+func atTarget(result GatewayResult) bool {
+	return result.Outcome == OutcomeUpgraded || result.UpToDate
+}
+
+func anyAtTarget(results []GatewayResult) bool {
+	for _, result := range results {
+		if atTarget(result) {
+			return true
+		}
+	}
+	return false
+}
+
+func gatewayNames(gateways []gatewayv2alpha2.Gateway) []string {
+	names := make([]string, 0, len(gateways))
+	for _, gw := range gateways {
+		names = append(names, fmt.Sprintf("%s/%s", gw.Namespace, gw.Name))
+	}
+	return names
+}
+
+// sleep waits for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
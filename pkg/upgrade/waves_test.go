@@ -0,0 +1,137 @@
+package upgrade
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	gatewayv2alpha2 "github.com/zhou1203/GatewayUpgradeTool/api/gateway/v2alpha2"
+	"github.com/zhou1203/GatewayUpgradeTool/cmd/upgrade/options"
+	"github.com/zhou1203/GatewayUpgradeTool/pkg/scheme"
+)
+
+func testGateway(namespace, name, wave string) gatewayv2alpha2.Gateway {
+	gw := gatewayv2alpha2.Gateway{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	if wave != "" {
+		gw.Labels = map[string]string{LabelUpgradeWave: wave}
+	}
+	return gw
+}
+
+func TestPlanWaves(t *testing.T) {
+	gateways := []gatewayv2alpha2.Gateway{
+		testGateway("a", "gw1", "10"),
+		testGateway("a", "gw2", "9"),
+		testGateway("b", "gw3", ""),
+		testGateway("b", "gw4", "9"),
+	}
+	tests := []struct {
+		name    string
+		spec    string
+		want    [][]string
+		wantErr string
+	}{
+		{name: "single wave", spec: "", want: [][]string{{"a/gw1", "a/gw2", "b/gw3", "b/gw4"}}},
+		{name: "canary", spec: WavesCanary, want: [][]string{{"a/gw1"}, {"a/gw2", "b/gw3", "b/gw4"}}},
+		{name: "label", spec: WavesLabel, want: [][]string{{"a/gw2", "b/gw4"}, {"a/gw1"}, {"b/gw3"}}},
+		{name: "list", spec: "gw3/b;gw1/a,gw2/a,gw4/b", want: [][]string{{"b/gw3"}, {"a/gw1", "a/gw2", "b/gw4"}}},
+		{name: "not selected", spec: "gw5/a;gw1/a,gw2/a,gw3/b,gw4/b", wantErr: "not selected"},
+		{name: "listed twice", spec: "gw1/a;gw1/a,gw2/a,gw3/b,gw4/b", wantErr: "more than one wave"},
+		{name: "not listed", spec: "gw1/a;gw2/a", wantErr: "not listed in any wave"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			waves, err := PlanWaves(gateways, tt.spec)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("PlanWaves() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]string
+			for _, wave := range waves {
+				got = append(got, gatewayNames(wave))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PlanWaves() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// healthyGateway returns a gateway which is deployed and ready.
+func healthyGateway(t *testing.T, namespace, name, appVersion string, ready bool) gatewayv2alpha2.Gateway {
+	t.Helper()
+	gw := testGateway(namespace, name, "")
+	gw.Spec.AppVersion = appVersion
+	readyStatus := metav1.ConditionFalse
+	if ready {
+		readyStatus = metav1.ConditionTrue
+	}
+	err := gw.SetStatus(&gatewayv2alpha2.Status{Conditions: []metav1.Condition{
+		{Type: gatewayv2alpha2.ConditionTypeDeployd, Status: metav1.ConditionTrue},
+		{Type: gatewayv2alpha2.ConditionTypeDeploymentReady, Status: readyStatus},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gw
+}
+
+func TestUpgradeInWavesCanary(t *testing.T) {
+	tests := []struct {
+		name         string
+		canary, rest gatewayv2alpha2.Gateway
+		want         []Outcome
+		wantErr      string
+	}{
+		{
+			// The canary is skipped for another reason, so it proves nothing.
+			name:    "canary not deployed",
+			canary:  testGateway("a", "gw1", ""),
+			rest:    healthyGateway(t, "a", "gw2", TargetVersion, true),
+			want:    []Outcome{OutcomeSkipped, OutcomeNotStarted},
+			wantErr: "no gateway of the wave was upgraded",
+		},
+		{
+			// A rerun after a partial upgrade continues with the next waves.
+			name:   "canary upgraded by an earlier run",
+			canary: healthyGateway(t, "a", "gw1", TargetVersion, true),
+			rest:   healthyGateway(t, "a", "gw2", TargetVersion, true),
+			want:   []Outcome{OutcomeSkipped, OutcomeSkipped},
+		},
+		{
+			name:    "canary upgraded by an earlier run is not ready",
+			canary:  healthyGateway(t, "a", "gw1", TargetVersion, false),
+			rest:    healthyGateway(t, "a", "gw2", TargetVersion, true),
+			want:    []Outcome{OutcomeSkipped, OutcomeNotStarted},
+			wantErr: "gateway a/gw1 is not healthy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runOptions := options.NewRunOptions()
+			runOptions.Waves = WavesCanary
+			r := &Runner{
+				Client:     fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(&tt.canary, &tt.rest).Build(),
+				RunOptions: *runOptions,
+			}
+
+			results, err := r.UpgradeInWaves(context.Background(), []gatewayv2alpha2.Gateway{tt.canary, tt.rest})
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("UpgradeInWaves() error = %v, want %q", err, tt.wantErr)
+			}
+			outcomes := []Outcome{results[0].Outcome, results[1].Outcome}
+			if !reflect.DeepEqual(outcomes, tt.want) {
+				t.Errorf("outcomes = %v, want %v", outcomes, tt.want)
+			}
+		})
+	}
+}